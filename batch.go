@@ -0,0 +1,117 @@
+package fished
+
+import (
+	"context"
+	"sync"
+)
+
+type (
+	// FactsIterator yields the fact sets of a batch one by one, Next returns false once exhausted
+	FactsIterator interface {
+		Next() (map[string]interface{}, bool)
+	}
+
+	// Result is the outcome of evaluating the fact set at Index of a batch
+	Result struct {
+		Index  int
+		Value  interface{}
+		Errors MultiError
+	}
+
+	sliceIterator struct {
+		facts []map[string]interface{}
+		next  int
+	}
+
+	batchItem struct {
+		facts  map[string]interface{}
+		result *Result
+	}
+)
+
+// SliceIterator iterates over a slice of fact sets
+func SliceIterator(facts []map[string]interface{}) FactsIterator {
+	return &sliceIterator{facts: facts}
+}
+
+func (it *sliceIterator) Next() (map[string]interface{}, bool) {
+	if it.next >= len(it.facts) {
+		return nil, false
+	}
+	facts := it.facts[it.next]
+	it.next++
+	return facts, true
+}
+
+// Concurrency bounds how many fact sets of a batch or a stream are evaluated at once.
+// It defaults to twice the engine workers, so workers stay busy while runs apply their waves.
+func Concurrency(n int) RunOption {
+	return func(c *runConfig) {
+		c.concurrency = n
+	}
+}
+
+// EvaluateBatch evaluates every fact set against the rules of the engine like Evaluate, in parallel on the
+// engine workers. Results are returned in the order of facts, each one with its own errors.
+func (e *Engine) EvaluateBatch(facts []map[string]interface{}, target string, opts ...RunOption) []Result {
+	return e.EvaluateBatchContext(context.Background(), SliceIterator(facts), target, opts...)
+}
+
+// EvaluateBatchContext is EvaluateBatch reading fact sets from an iterator, it stops reading as soon as ctx is done.
+// Fact sets read before that are still reported, with ErrTimeout or ErrCanceled when they could not finish.
+// Tracing is not supported in a batch and WithTrace is ignored.
+func (e *Engine) EvaluateBatchContext(ctx context.Context, it FactsIterator, target string, opts ...RunOption) []Result {
+	config := newRunConfig(opts)
+	config.trace = nil
+
+	items := make(chan batchItem)
+	var runs sync.WaitGroup
+	for i := 0; i < e.concurrency(config); i++ {
+		runs.Add(1)
+		go func() {
+			defer runs.Done()
+			for item := range items {
+				item.result.Value, item.result.Errors = e.evaluate(ctx, item.facts, target, config)
+			}
+		}()
+	}
+
+	var results []*Result
+	for ctx.Err() == nil {
+		facts, ok := it.Next()
+		if !ok {
+			break
+		}
+		result := &Result{Index: len(results)}
+		results = append(results, result)
+
+		select {
+		case items <- batchItem{facts: facts, result: result}:
+		case <-ctx.Done():
+			result.Errors = append(result.Errors, contextError(ctx.Err()))
+		}
+	}
+	close(items)
+	runs.Wait()
+
+	batch := make([]Result, len(results))
+	for i, result := range results {
+		batch[i] = *result
+	}
+	return batch
+}
+
+// evaluate runs a single fact set of a batch or a stream, config is shared by all of them and copied here
+func (e *Engine) evaluate(ctx context.Context, facts map[string]interface{}, target string, config runConfig) (interface{}, MultiError) {
+	config.facts = facts
+	result := e.run(ctx, target, config)
+	return result.Value, result.Errors
+}
+
+// concurrency is the number of runs of a batch or a stream evaluated at once
+func (e *Engine) concurrency(config runConfig) int {
+	if config.concurrency > 0 {
+		return config.concurrency
+	}
+	return 2 * e.scheduler.size
+}
@@ -0,0 +1,107 @@
+package fished
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingIterator struct {
+	n, next int
+}
+
+func (it *countingIterator) Next() (map[string]interface{}, bool) {
+	if it.next >= it.n {
+		return nil, false
+	}
+	it.next++
+	return map[string]interface{}{"user": float64(it.next - 1)}, true
+}
+
+func batchEngine() *Engine {
+	e := NewWithCustomWorkerSize(2)
+	e.Set(map[string]interface{}{"bonus": 1}, []Rule{
+		{Input: []string{"user"}, Output: "score", Expression: "check(user) + bonus"},
+		{Input: []string{"score"}, Output: DefaultTarget, Expression: "score * 2"},
+	}, map[string]RuleFunction{
+		"check": func(args ...interface{}) (interface{}, error) {
+			if args[0].(float64) == 3 {
+				return nil, errors.New("blocked user")
+			}
+			return args[0], nil
+		},
+	})
+	return e
+}
+
+func TestEvaluateBatch(t *testing.T) {
+	e := batchEngine()
+	defer e.Close()
+
+	facts := make([]map[string]interface{}, 100)
+	for i := range facts {
+		facts[i] = map[string]interface{}{"user": float64(i)}
+	}
+
+	results := e.EvaluateBatch(facts, DefaultTarget)
+	assert.Len(t, results, len(facts))
+	for i, result := range results {
+		assert.Equal(t, i, result.Index)
+		if i == 3 {
+			assert.Nil(t, result.Value)
+			assert.Len(t, result.Errors, 1)
+			continue
+		}
+		assert.Nil(t, result.Errors)
+		assert.Equal(t, float64(2*(i+1)), result.Value)
+	}
+
+	t.Run("iterator", func(t *testing.T) {
+		results := e.EvaluateBatchContext(context.Background(), &countingIterator{n: 10}, DefaultTarget, Concurrency(3))
+		assert.Len(t, results, 10)
+		assert.Equal(t, float64(2*10), results[9].Value)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		results := e.EvaluateBatchContext(ctx, &countingIterator{n: 10}, DefaultTarget)
+		assert.Empty(t, results)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		assert.Empty(t, e.EvaluateBatch(nil, DefaultTarget))
+	})
+}
+
+func BenchmarkEvaluateBatch(b *testing.B) {
+	for _, size := range []int{100, 1000} {
+		facts := make([]map[string]interface{}, size)
+		for i := range facts {
+			facts[i] = map[string]interface{}{"user": float64(i + 4)}
+		}
+
+		b.Run(fmt.Sprintf("loop %d", size), func(b *testing.B) {
+			e := batchEngine()
+			defer e.Close()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, f := range facts {
+					e.Evaluate(f, DefaultTarget)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("batch %d", size), func(b *testing.B) {
+			e := batchEngine()
+			defer e.Close()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				e.EvaluateBatch(facts, DefaultTarget)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package fished
+
+import (
+	"fmt"
+	"reflect"
+)
+
+const (
+	// ConflictLastByIndex keeps the value of the rule with the highest index
+	ConflictLastByIndex ConflictStrategy = iota
+	// ConflictFirstWins keeps the first value known for an output, initial facts included.
+	// Within a wave rules fire by highest Priority, then by lowest index.
+	ConflictFirstWins
+	// ConflictPriority keeps the value of the rule with the highest Priority, ties are broken by highest index.
+	// It is the default strategy, which behaves like ConflictLastByIndex when no rule sets a Priority.
+	ConflictPriority
+	// ConflictReject keeps the first value produced by a rule and reports a ConflictError
+	// when another rule produces a different value for the same output
+	ConflictReject
+)
+
+type (
+	// ConflictStrategy decides which value is kept when several rules produce the same output.
+	// A rule always overrides an initial fact, except with ConflictFirstWins.
+	// Whatever the strategy, rules depending on an output only fire once, after every rule producing it fired,
+	// so they see its final value. Only when the remaining producers can never fire, such as in a cycle,
+	// are they released with the value known so far.
+	ConflictStrategy int
+
+	// ConflictError is reported by ConflictReject when two rules produce different values for the same output
+	ConflictError struct {
+		Output  string
+		Rules   [2]int
+		RuleIDs [2]string
+		Values  [2]interface{}
+	}
+
+	conflictConfig struct {
+		strategy ConflictStrategy
+		outputs  map[string]ConflictStrategy
+	}
+)
+
+// SetConflictStrategy will set the conflict strategy used for every output without its own strategy
+func (e *Engine) SetConflictStrategy(strategy ConflictStrategy) error {
+	e.update(func(s *snapshot) {
+		s.conflicts.strategy = strategy
+	})
+	return nil
+}
+
+// SetOutputConflictStrategy will set the conflict strategy used for a single output
+func (e *Engine) SetOutputConflictStrategy(output string, strategy ConflictStrategy) error {
+	e.update(func(s *snapshot) {
+		// runs may still read the old map, so it is replaced instead of modified
+		outputs := make(map[string]ConflictStrategy, len(s.conflicts.outputs)+1)
+		for key, value := range s.conflicts.outputs {
+			outputs[key] = value
+		}
+		outputs[output] = strategy
+		s.conflicts.outputs = outputs
+	})
+	return nil
+}
+
+// Cycles returns the rules depending on each other through their input and output.
+// Rules of a cycle never fire unless one of the facts in the cycle is an initial fact.
+func (rs *RuleSet) Cycles() [][]int {
+	return findCycles(rs.rules)
+}
+
+// resolve decides whether the value produced by evalResult replaces the current value of its output,
+// which was produced by the rule owner, or is an initial fact when owner is negative
+func (c conflictConfig) resolve(rs *RuleSet, owner int, current interface{}, evalResult *EvalResult) (bool, error) {
+	strategy, ok := c.outputs[evalResult.Key]
+	if !ok {
+		strategy = c.strategy
+	}
+
+	switch strategy {
+	case ConflictFirstWins:
+		return false, nil
+	case ConflictPriority:
+		if owner < 0 {
+			return true, nil
+		}
+		priority, ownerPriority := rs.rules[evalResult.Rule].Priority, rs.rules[owner].Priority
+		return priority > ownerPriority || (priority == ownerPriority && evalResult.Rule > owner), nil
+	case ConflictReject:
+		if owner < 0 {
+			return true, nil
+		}
+		if !reflect.DeepEqual(current, evalResult.Value) {
+			return false, &ConflictError{
+				Output:  evalResult.Key,
+				Rules:   [2]int{owner, evalResult.Rule},
+				RuleIDs: [2]string{rs.rules[owner].ID, rs.rules[evalResult.Rule].ID},
+				Values:  [2]interface{}{current, evalResult.Value},
+			}
+		}
+		return false, nil
+	default:
+		return evalResult.Rule > owner, nil
+	}
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s and %s produce conflicting values %v and %v for %q",
+		ruleName(e.Rules[0], e.RuleIDs[0]), ruleName(e.Rules[1], e.RuleIDs[1]), e.Values[0], e.Values[1], e.Output)
+}
@@ -0,0 +1,143 @@
+package fished
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflictStrategy(t *testing.T) {
+	// decision is produced by rules 1 and 2 in the same wave and by rule 0 once account_type is settled,
+	// account_type overrides an initial fact
+	rules := []Rule{
+		{Input: []string{"account_type"}, Output: "decision", Expression: "'late'", Priority: 1},
+		{Input: []string{"account_partner"}, Output: "decision", Expression: "'override'", Priority: 10},
+		{Input: []string{"account_partner"}, Output: "decision", Expression: "'default'"},
+		{Input: []string{"account_partner"}, Output: "account_type", Expression: "'free'"},
+	}
+	facts := map[string]interface{}{
+		"account_partner": "hello",
+		"account_type":    "paid",
+	}
+
+	tc := []struct {
+		Name             string
+		Strategy         ConflictStrategy
+		ExpectedDecision interface{}
+		ExpectedType     interface{}
+		IsError          bool
+	}{
+		{Name: "last by index", Strategy: ConflictLastByIndex, ExpectedDecision: "default", ExpectedType: "free"},
+		{Name: "first wins", Strategy: ConflictFirstWins, ExpectedDecision: "override", ExpectedType: "paid"},
+		{Name: "priority", Strategy: ConflictPriority, ExpectedDecision: "override", ExpectedType: "free"},
+		{Name: "reject", Strategy: ConflictReject, ExpectedDecision: "override", ExpectedType: "free", IsError: true},
+	}
+
+	for _, test := range tc {
+		t.Run(test.Name, func(t *testing.T) {
+			e := New()
+			defer e.Close()
+			e.Set(facts, rules, nil)
+			e.SetConflictStrategy(test.Strategy)
+
+			// the outcome must not depend on the order workers finish in
+			for i := 0; i < 20; i++ {
+				var trace Trace
+				res, errs := e.RunContext(context.Background(), "decision", WithTrace(&trace))
+				assert.Equal(t, test.ExpectedDecision, res)
+				assert.Equal(t, test.ExpectedDecision, trace.Explain("decision").Value)
+				assert.Equal(t, test.ExpectedType, trace.Explain("account_type").Value)
+
+				if test.IsError {
+					if assert.Len(t, errs, 2) {
+						conflictErr := errs[0].(*ConflictError)
+						assert.Equal(t, "decision", conflictErr.Output)
+						assert.Equal(t, [2]int{1, 2}, conflictErr.Rules)
+						assert.Equal(t, [2]interface{}{"override", "default"}, conflictErr.Values)
+						conflictErr = errs[1].(*ConflictError)
+						assert.Equal(t, [2]int{1, 0}, conflictErr.Rules)
+						assert.Equal(t, [2]interface{}{"override", "late"}, conflictErr.Values)
+					}
+				} else {
+					assert.Nil(t, errs)
+				}
+			}
+		})
+	}
+
+	t.Run("per output strategy", func(t *testing.T) {
+		e := New()
+		defer e.Close()
+		e.Set(facts, rules, nil)
+		e.SetConflictStrategy(ConflictFirstWins)
+		e.SetOutputConflictStrategy("decision", ConflictPriority)
+
+		var trace Trace
+		res, errs := e.RunContext(context.Background(), "decision", WithTrace(&trace))
+		assert.Nil(t, errs)
+		assert.Equal(t, "override", res)
+		assert.Equal(t, "paid", trace.Explain("account_type").Value)
+	})
+}
+
+func TestConflictAcrossWaves(t *testing.T) {
+	// the override of account_type fires a wave after the default, isEligible must only see the final value
+	rules := []Rule{
+		{Input: []string{"account_partner"}, Output: "account_type", Expression: "'free'"},
+		{Input: []string{"account_partner"}, Output: "partner_plan", Expression: "'paid'"},
+		{Input: []string{"partner_plan"}, Output: "account_type", Expression: "partner_plan", Priority: 10},
+		{Input: []string{"account_type"}, Output: "isEligible", Expression: "account_type == 'free'"},
+	}
+
+	e := New()
+	defer e.Close()
+	e.Set(map[string]interface{}{"account_partner": "hello"}, rules, nil)
+
+	var trace Trace
+	res, errs := e.RunContext(context.Background(), "isEligible", WithTrace(&trace))
+	assert.Nil(t, errs)
+	assert.Equal(t, false, res)
+	assert.Equal(t, "paid", trace.Explain("account_type").Value)
+	eligible := trace.Explain("isEligible").Step
+	assert.Equal(t, map[string]interface{}{"account_type": "paid"}, eligible.Inputs)
+	assert.Equal(t, 3, eligible.Wave)
+}
+
+func TestPriorityFiringOrder(t *testing.T) {
+	rules := []Rule{
+		{Input: []string{"seed"}, Output: "low", Expression: "seed"},
+		{Input: []string{"seed"}, Output: "high", Expression: "seed", Priority: 5},
+		{Input: []string{"seed"}, Output: "middle", Expression: "seed", Priority: 1},
+		{Input: []string{"seed"}, Output: "negative", Expression: "seed", Priority: -1},
+		{Input: []string{"seed"}, Output: "default", Expression: "seed"},
+	}
+
+	e := New()
+	defer e.Close()
+	e.Set(map[string]interface{}{"seed": true}, rules, nil)
+
+	var trace Trace
+	_, errs := e.RunContext(context.Background(), DefaultTarget, WithTrace(&trace))
+	assert.Nil(t, errs)
+
+	var fired []int
+	for _, step := range trace.Steps {
+		fired = append(fired, step.Rule)
+	}
+	assert.Equal(t, []int{1, 2, 0, 4, 3}, fired)
+}
+
+func TestCycles(t *testing.T) {
+	rs, err := Compile([]Rule{
+		{Input: []string{"b"}, Output: "a", Expression: "b"},
+		{Input: []string{"seed"}, Output: "c", Expression: "seed"},
+		{Input: []string{"a", "c"}, Output: "b", Expression: "a && c"},
+		{Input: []string{"d"}, Output: "d", Expression: "d"},
+	}, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, [][]int{{0, 2}, {3}}, rs.Cycles())
+}
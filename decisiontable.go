@@ -0,0 +1,497 @@
+package fished
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/knetic/govaluate"
+)
+
+const (
+	// HitUnique expects at most one row to match, several matching rows are an error
+	HitUnique HitPolicy = iota
+	// HitFirst returns the output of the first matching row, in table order
+	HitFirst
+	// HitAny allows several rows to match as long as they agree on the output
+	HitAny
+	// HitCollect returns the outputs of every matching row as a list, in table order
+	HitCollect
+)
+
+// outputPrefix marks the output columns in the header of a CSV decision table
+const outputPrefix = "out:"
+
+// hitPolicyField starts the optional line setting the hit policy of a CSV decision table, above its header
+const hitPolicyField = "hit_policy"
+
+type (
+	// HitPolicy decides the output of a decision table when several rows match
+	HitPolicy int
+
+	// DecisionTable maps conditions on facts to outputs, one row at a time. Inputs and Outputs name the columns,
+	// every row holds a cell for each input then a cell for each output.
+	//
+	// An input cell is a test on its column: empty or "-" matches anything, a cell starting with a comparison
+	// operator such as "> 18" or "!= 'ID'" compares the column with the rest of the cell, a list such as "'ID', 'SG'"
+	// matches any of its values and any other cell must be equal to the column. An output cell is an expression.
+	// When no row matches, the output is nil, or an empty list under HitCollect.
+	DecisionTable struct {
+		ID          string     `json:"id,omitempty"`
+		Name        string     `json:"name,omitempty"`
+		Description string     `json:"description,omitempty"`
+		HitPolicy   HitPolicy  `json:"hit_policy"`
+		Inputs      []string   `json:"inputs"`
+		Outputs     []string   `json:"outputs"`
+		Rows        [][]string `json:"rows"`
+	}
+)
+
+var hitPolicyNames = map[HitPolicy]string{
+	HitUnique:  "unique",
+	HitFirst:   "first",
+	HitAny:     "any",
+	HitCollect: "collect",
+}
+
+// reservedPrefix starts the names of the builtin functions, rule functions can not use it
+const reservedPrefix = "fished_"
+
+// hitPolicyFunctions names the builtin function applying each hit policy
+var hitPolicyFunctions = map[HitPolicy]string{
+	HitUnique:  "fished_hit_unique",
+	HitFirst:   "fished_hit_first",
+	HitAny:     "fished_hit_any",
+	HitCollect: "fished_hit_collect",
+}
+
+// builtinFunctions are registered in every rule set, so the rules of decision tables run wherever they are compiled.
+// The hit policy functions take pairs of a row condition and the output of the row, and apply a hit policy to them.
+var builtinFunctions = map[string]RuleFunction{
+	"fished_hit_unique": func(args ...interface{}) (interface{}, error) {
+		matched, err := matchedRows(args)
+		if err != nil || len(matched) == 0 {
+			return nil, err
+		}
+		if len(matched) > 1 {
+			return nil, fmt.Errorf("rows %d and %d both match, hit policy unique allows one", matched[0]+1, matched[1]+1)
+		}
+		return args[2*matched[0]+1], nil
+	},
+	"fished_hit_first": func(args ...interface{}) (interface{}, error) {
+		matched, err := matchedRows(args)
+		if err != nil || len(matched) == 0 {
+			return nil, err
+		}
+		return args[2*matched[0]+1], nil
+	},
+	"fished_hit_any": func(args ...interface{}) (interface{}, error) {
+		matched, err := matchedRows(args)
+		if err != nil || len(matched) == 0 {
+			return nil, err
+		}
+		for _, row := range matched[1:] {
+			if !reflect.DeepEqual(args[2*row+1], args[2*matched[0]+1]) {
+				return nil, fmt.Errorf("rows %d and %d match with different outputs, hit policy any needs them to agree", matched[0]+1, row+1)
+			}
+		}
+		return args[2*matched[0]+1], nil
+	},
+	"fished_hit_collect": func(args ...interface{}) (interface{}, error) {
+		matched, err := matchedRows(args)
+		if err != nil {
+			return nil, err
+		}
+		outputs := make([]interface{}, len(matched))
+		for i, row := range matched {
+			outputs[i] = args[2*row+1]
+		}
+		return outputs, nil
+	},
+}
+
+// matchedRows returns the index of every row whose condition holds, args being pairs of a condition and an output
+func matchedRows(args []interface{}) ([]int, error) {
+	if len(args)%2 != 0 {
+		return nil, errors.New("decision functions take pairs of a condition and an output")
+	}
+	var matched []int
+	for i := 0; i < len(args); i += 2 {
+		ok, isBool := args[i].(bool)
+		if !isBool {
+			return nil, fmt.Errorf("row %d: condition is %T, not bool", i/2+1, args[i])
+		}
+		if ok {
+			matched = append(matched, i/2)
+		}
+	}
+	return matched, nil
+}
+
+// LoadDecisionTable reads a JSON decision table. Unknown fields are rejected and every error is a *LoadError.
+func LoadDecisionTable(r io.Reader) (*DecisionTable, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, new(json.RawMessage)); err != nil {
+		return nil, newLoadError(data, 0, err)
+	}
+
+	fields, ok := objectFields(data, 0)
+	if !ok {
+		return nil, newLoadError(data, 0, errors.New("decision table must be a JSON object"))
+	}
+	t := &DecisionTable{}
+	for _, field := range fields {
+		var value interface{}
+		switch field.key {
+		case "id":
+			value = &t.ID
+		case "name":
+			value = &t.Name
+		case "description":
+			value = &t.Description
+		case "hit_policy":
+			value = &t.HitPolicy
+		case "inputs":
+			value = &t.Inputs
+		case "outputs":
+			value = &t.Outputs
+		case "rows":
+			value = &t.Rows
+		default:
+			return nil, newLoadError(data, field.keyOffset, fmt.Errorf("unknown field %q", field.key))
+		}
+		if err := json.Unmarshal(field.value, value); err != nil {
+			return nil, newLoadError(data, field.valueOffset, err)
+		}
+	}
+	for i, row := range t.Rows {
+		if len(row) != len(t.Inputs)+len(t.Outputs) {
+			return nil, &LoadError{Err: fmt.Errorf("row %d has %d cells, expected %d", i+1, len(row), len(t.Inputs)+len(t.Outputs))}
+		}
+	}
+	return t, nil
+}
+
+// LoadDecisionTableCSV reads a CSV decision table. The header names the columns, output columns are prefixed
+// with "out:" and come after the input columns. The header may follow a hit_policy line such as
+// "hit_policy,first", the hit policy is HitUnique otherwise.
+func LoadDecisionTableCSV(r io.Reader) (*DecisionTable, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	// the hit policy line is narrower than the table, so the width of the records is checked below
+	reader.FieldsPerRecord = -1
+
+	t := &DecisionTable{}
+	var records [][]string
+	var lines []int
+	first := true
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, &LoadError{Line: parseErr.Line, Column: parseErr.Column, Err: parseErr.Err}
+			}
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		if first && strings.TrimSpace(record[0]) == hitPolicyField {
+			if err := t.readHitPolicy(record); err != nil {
+				err.Line = line
+				return nil, err
+			}
+			first = false
+			continue
+		}
+		first = false
+		records = append(records, record)
+		lines = append(lines, line)
+	}
+	if len(records) == 0 {
+		return nil, &LoadError{Line: 1, Err: errors.New("missing header")}
+	}
+
+	for i, column := range records[0] {
+		column = strings.TrimSpace(column)
+		if strings.HasPrefix(column, outputPrefix) {
+			t.Outputs = append(t.Outputs, strings.TrimSpace(strings.TrimPrefix(column, outputPrefix)))
+			continue
+		}
+		if len(t.Outputs) > 0 {
+			return nil, &LoadError{Line: lines[0], Column: i + 1, Err: fmt.Errorf("input column %q after output columns", column)}
+		}
+		t.Inputs = append(t.Inputs, column)
+	}
+	if len(t.Outputs) == 0 {
+		return nil, &LoadError{Line: lines[0], Err: errors.New("no output column, prefix output columns with " + outputPrefix)}
+	}
+	for k, row := range records[1:] {
+		if len(row) != len(records[0]) {
+			return nil, &LoadError{Line: lines[k+1], Column: 1, Err: csv.ErrFieldCount}
+		}
+	}
+	t.Rows = records[1:]
+	return t, nil
+}
+
+// readHitPolicy reads the hit_policy line of a CSV table, padded with empty fields by spreadsheets
+func (t *DecisionTable) readHitPolicy(record []string) *LoadError {
+	if len(record) < 2 {
+		return &LoadError{Column: 1, Err: errors.New("missing hit policy")}
+	}
+	for k, field := range record[2:] {
+		if strings.TrimSpace(field) != "" {
+			return &LoadError{Column: k + 3, Err: fmt.Errorf("unexpected field %q after the hit policy", field)}
+		}
+	}
+	if err := t.HitPolicy.UnmarshalText([]byte(strings.TrimSpace(record[1]))); err != nil {
+		return &LoadError{Column: 2, Err: err}
+	}
+	return nil
+}
+
+// LoadDecisionTableFile reads the decision table at path, as CSV when its extension is .csv and as JSON otherwise
+func LoadDecisionTableFile(path string) (*DecisionTable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var t *DecisionTable
+	if strings.ToLower(filepath.Ext(path)) == ".csv" {
+		t, err = LoadDecisionTableCSV(file)
+	} else {
+		t, err = LoadDecisionTable(file)
+	}
+	var loadErr *LoadError
+	if errors.As(err, &loadErr) {
+		loadErr.Path = path
+	}
+	return t, err
+}
+
+// Rules compiles the table into one rule per output column, which runs on any engine. The rule is named after
+// the table, its ID is the table ID, followed by "/" and the output when the table has several outputs.
+// The input of the rule lists every fact its cells use, so it fires once all of them are known.
+func (t *DecisionTable) Rules() ([]Rule, error) {
+	return t.rules(unaryTest)
+}
+
+// rules compiles the table like Rules, test translates an input cell into a condition on its column
+func (t *DecisionTable) rules(test func(column, cell string) (string, error)) ([]Rule, error) {
+	if len(t.Outputs) == 0 {
+		return nil, t.errorf("no output column")
+	}
+	function, ok := hitPolicyFunctions[t.HitPolicy]
+	if !ok {
+		return nil, t.errorf("unknown hit policy %d", int(t.HitPolicy))
+	}
+
+	conditions := make([]string, len(t.Rows))
+	for i, row := range t.Rows {
+		if len(row) != len(t.Inputs)+len(t.Outputs) {
+			return nil, t.errorf("row %d has %d cells, expected %d", i+1, len(row), len(t.Inputs)+len(t.Outputs))
+		}
+		var tests []string
+		for j, input := range t.Inputs {
+			condition, err := test(input, row[j])
+			if err != nil {
+				return nil, t.errorf("row %d, column %q: %v", i+1, input, err)
+			}
+			if condition != "" {
+				tests = append(tests, condition)
+			}
+		}
+		conditions[i] = "true"
+		if len(tests) > 0 {
+			conditions[i] = strings.Join(tests, " && ")
+		}
+	}
+
+	rules := make([]Rule, len(t.Outputs))
+	for k, output := range t.Outputs {
+		var expression strings.Builder
+		expression.WriteString(function + "(")
+		for i, row := range t.Rows {
+			cell := strings.TrimSpace(row[len(t.Inputs)+k])
+			if cell == "" {
+				return nil, t.errorf("row %d, column %q: empty output", i+1, output)
+			}
+			if _, err := parseCell(cell); err != nil {
+				return nil, t.errorf("row %d, column %q: %v", i+1, output, err)
+			}
+			if i > 0 {
+				expression.WriteString(",")
+			}
+			expression.WriteString("\n    " + conditions[i] + ", " + cell)
+		}
+		expression.WriteString("\n)")
+
+		id := t.ID
+		if id != "" && len(t.Outputs) > 1 {
+			id += "/" + output
+		}
+		inputs, err := expressionInputs(expression.String())
+		if err != nil {
+			return nil, t.errorf("column %q: %v", output, err)
+		}
+		rules[k] = Rule{
+			ID:          id,
+			Name:        t.Name,
+			Description: t.Description,
+			Input:       inputs,
+			Output:      output,
+			Expression:  expression.String(),
+		}
+	}
+	return rules, nil
+}
+
+// Compile compiles the rules of the table like Compile. A table with a single output targets it by default.
+func (t *DecisionTable) Compile(ruleFunctions map[string]RuleFunction) (*RuleSet, error) {
+	rules, err := t.Rules()
+	if err != nil {
+		return nil, err
+	}
+	rs, err := Compile(rules, ruleFunctions)
+	if err != nil {
+		return nil, err
+	}
+	rs.name = t.Name
+	if len(t.Outputs) == 1 {
+		rs.target = t.Outputs[0]
+	}
+	return rs, nil
+}
+
+// errorf returns an error naming the table, by ID or by name when it has one
+func (t *DecisionTable) errorf(format string, args ...interface{}) error {
+	name := t.ID
+	if name == "" {
+		name = t.Name
+	}
+	if name == "" {
+		return fmt.Errorf("fished: decision table: "+format, args...)
+	}
+	return fmt.Errorf("fished: decision table %q: "+format, append([]interface{}{name}, args...)...)
+}
+
+// unaryTest translates an input cell into a condition on column, empty when the cell matches anything
+func unaryTest(column, cell string) (string, error) {
+	cell = strings.TrimSpace(cell)
+	if cell == "" || cell == "-" {
+		return "", nil
+	}
+
+	var test string
+	switch values := splitList(cell); {
+	case len(values) > 1:
+		test = column + " IN (" + strings.Join(values, ", ") + ")"
+	case strings.HasPrefix(cell, "=="), strings.HasPrefix(cell, "!="),
+		strings.HasPrefix(cell, "<"), strings.HasPrefix(cell, ">"),
+		strings.HasPrefix(cell, "=~"), strings.HasPrefix(cell, "!~"):
+		test = column + " " + cell
+	default:
+		test = column + " == " + cell
+	}
+	if _, err := parseCell(test); err != nil {
+		return "", err
+	}
+	return "(" + test + ")", nil
+}
+
+// splitList splits a cell on the commas outside of strings, parentheses and brackets
+func splitList(cell string) []string {
+	var values []string
+	var quote rune
+	depth, start := 0, 0
+	for i, c := range cell {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			values = append(values, strings.TrimSpace(cell[start:i]))
+			start = i + 1
+		}
+	}
+	return append(values, strings.TrimSpace(cell[start:]))
+}
+
+// parseCell parses a cell on its own, so errors point to the cell rather than to the whole rule.
+// Functions are checked once the rules are compiled, they are stubbed here.
+func parseCell(expression string) (*govaluate.EvaluableExpression, error) {
+	functions := make(map[string]govaluate.ExpressionFunction)
+	stubFunctions(expression, functions)
+	return govaluate.NewEvaluableExpressionWithFunctions(expression, functions)
+}
+
+// expressionInputs lists the variables of expression once each, in order of appearance
+func expressionInputs(expression string) ([]string, error) {
+	parsed, err := parseCell(expression)
+	if err != nil {
+		return nil, err
+	}
+	inputs := []string{}
+	seen := make(map[string]struct{})
+	for _, variable := range parsed.Vars() {
+		if _, ok := seen[variable]; !ok {
+			seen[variable] = struct{}{}
+			inputs = append(inputs, variable)
+		}
+	}
+	return inputs, nil
+}
+
+func (p HitPolicy) String() string {
+	if name, ok := hitPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("HitPolicy(%d)", int(p))
+}
+
+// MarshalText encodes the hit policy by name
+func (p HitPolicy) MarshalText() ([]byte, error) {
+	name, ok := hitPolicyNames[p]
+	if !ok {
+		return nil, fmt.Errorf("fished: unknown hit policy %d", int(p))
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText decodes a hit policy encoded by MarshalText, an empty name is HitUnique
+func (p *HitPolicy) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*p = HitUnique
+		return nil
+	}
+	for policy, name := range hitPolicyNames {
+		if name == string(text) {
+			*p = policy
+			return nil
+		}
+	}
+	return fmt.Errorf("fished: unknown hit policy %q", text)
+}
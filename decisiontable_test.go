@@ -0,0 +1,230 @@
+package fished
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecisionTable(t *testing.T) {
+	expected, err := LoadDecisionTableFile("./test/tc9.json")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, HitFirst, expected.HitPolicy)
+
+	table, err := LoadDecisionTableFile("./test/tc9.csv")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, HitFirst, table.HitPolicy)
+	table.ID, table.Name = expected.ID, expected.Name
+	assert.Equal(t, expected, table)
+
+	rules, err := table.Rules()
+	if !assert.Nil(t, err) || !assert.Len(t, rules, 2) {
+		return
+	}
+	assert.Equal(t, "eligibility/isEligible", rules[0].ID)
+	assert.Equal(t, "Partner eligibility", rules[0].Name)
+	assert.Equal(t, []string{"account_partner", "account_region", "flight_type"}, rules[0].Input)
+	assert.Equal(t, "discount", rules[1].Output)
+	assert.Empty(t, Lint(rules, nil, LintOptions{Targets: []string{"isEligible", "discount"}}))
+
+	// the hit policy functions are builtin, the rules run on any engine
+	plain := New()
+	defer plain.Close()
+	assert.Nil(t, plain.SetRules(rules))
+	res, errs := plain.Evaluate(map[string]interface{}{"account_partner": "hello", "account_region": "MY", "flight_type": "domestic"}, "discount")
+	assert.Nil(t, errs)
+	assert.Equal(t, 0.1, res)
+
+	_, err = Compile(rules, map[string]RuleFunction{"fished_hit_first": builtinFunctions["fished_hit_first"]})
+	assert.True(t, errors.Is(err, ErrReservedFunction))
+
+	rs, err := table.Compile(nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "Partner eligibility", rs.Name())
+
+	e := New()
+	defer e.Close()
+	e.SetRuleSet(rs)
+
+	tc := []struct {
+		Name             string
+		Facts            map[string]interface{}
+		ExpectedEligible interface{}
+		ExpectedDiscount interface{}
+	}{
+		{
+			Name:             "first row",
+			Facts:            map[string]interface{}{"account_partner": "hello", "account_region": "SG", "flight_type": "domestic"},
+			ExpectedEligible: true,
+			ExpectedDiscount: 0.2,
+		},
+		{
+			Name:             "second row",
+			Facts:            map[string]interface{}{"account_partner": "hello", "account_region": "MY", "flight_type": "domestic"},
+			ExpectedEligible: true,
+			ExpectedDiscount: 0.1,
+		},
+		{
+			Name:             "catch all row",
+			Facts:            map[string]interface{}{"account_partner": "other", "account_region": "ID", "flight_type": "domestic"},
+			ExpectedEligible: false,
+			ExpectedDiscount: float64(0),
+		},
+	}
+
+	for _, test := range tc {
+		t.Run(test.Name, func(t *testing.T) {
+			res := e.RunDetailed(context.Background(), "isEligible", WithFacts(test.Facts), WithTargets("discount"))
+			assert.Nil(t, res.Errors)
+			assert.Equal(t, test.ExpectedEligible, res.Value)
+			assert.Equal(t, test.ExpectedDiscount, res.Targets["discount"])
+		})
+	}
+}
+
+func TestDecisionTableHitPolicy(t *testing.T) {
+	rows := [][]string{
+		{"> 5", "'big'"},
+		{"> 10", "'huge'"},
+		{"< 0", "'negative'"},
+	}
+
+	tc := []struct {
+		Name          string
+		Policy        HitPolicy
+		Rows          [][]string
+		Size          float64
+		Expected      interface{}
+		ExpectedError string
+	}{
+		{Name: "unique", Policy: HitUnique, Rows: rows, Size: 7, Expected: "big"},
+		{Name: "unique without match", Policy: HitUnique, Rows: rows, Size: 3, Expected: nil},
+		{Name: "unique with several matches", Policy: HitUnique, Rows: rows, Size: 12, ExpectedError: "rows 1 and 2 both match"},
+		{Name: "first", Policy: HitFirst, Rows: rows, Size: 12, Expected: "big"},
+		{Name: "any", Policy: HitAny, Rows: [][]string{{"> 5", "'big'"}, {"> 10", "'big'"}}, Size: 12, Expected: "big"},
+		{Name: "any with different outputs", Policy: HitAny, Rows: rows, Size: 12, ExpectedError: "rows 1 and 2 match with different outputs"},
+		{Name: "collect", Policy: HitCollect, Rows: rows, Size: 12, Expected: []interface{}{"big", "huge"}},
+		{Name: "collect without match", Policy: HitCollect, Rows: rows, Size: 3, Expected: []interface{}{}},
+		{Name: "list and equality", Policy: HitFirst, Rows: [][]string{{"1, 2, 3", "'small'"}, {"7", "'seven'"}}, Size: 7, Expected: "seven"},
+	}
+
+	for _, test := range tc {
+		t.Run(test.Name, func(t *testing.T) {
+			table := &DecisionTable{
+				ID:        "size",
+				HitPolicy: test.Policy,
+				Inputs:    []string{"size"},
+				Outputs:   []string{"label"},
+				Rows:      test.Rows,
+			}
+			rs, err := table.Compile(nil)
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, "label", rs.Target())
+
+			e := New()
+			defer e.Close()
+			e.SetRuleSet(rs)
+			res, errs := e.Evaluate(map[string]interface{}{"size": test.Size}, "label")
+			if test.ExpectedError == "" {
+				assert.Nil(t, errs)
+				assert.Equal(t, test.Expected, res)
+				return
+			}
+
+			var ruleErr *RuleError
+			if assert.True(t, errors.As(errs, &ruleErr)) {
+				assert.Equal(t, "size", ruleErr.RuleID)
+				assert.Equal(t, PhaseFunction, ruleErr.Phase)
+				assert.Contains(t, ruleErr.Error(), test.ExpectedError)
+			}
+		})
+	}
+}
+
+func TestDecisionTableErrors(t *testing.T) {
+	t.Run("invalid cell", func(t *testing.T) {
+		table := &DecisionTable{
+			Name:    "size",
+			Inputs:  []string{"size"},
+			Outputs: []string{"label"},
+			Rows:    [][]string{{"> 5", "'big'"}, {"> (", "'huge'"}},
+		}
+		_, err := table.Rules()
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), `decision table "size": row 2, column "size"`)
+		}
+	})
+
+	t.Run("csv hit policy line", func(t *testing.T) {
+		// spreadsheets pad every line to the width of the table and may quote every field
+		for _, line := range []string{"hit_policy,first,,", `"hit_policy","first"`, "hit_policy,first"} {
+			table, err := LoadDecisionTableCSV(strings.NewReader(line + "\nsize,out:label,out:size\n> 5,'big',1\n"))
+			if assert.Nil(t, err, line) {
+				assert.Equal(t, HitFirst, table.HitPolicy, line)
+				assert.Equal(t, []string{"label", "size"}, table.Outputs, line)
+				assert.Len(t, table.Rows, 1, line)
+			}
+		}
+	})
+
+	t.Run("output cells may use other facts and functions", func(t *testing.T) {
+		table := &DecisionTable{
+			Inputs:  []string{"size"},
+			Outputs: []string{"price"},
+			Rows:    [][]string{{"> 5", "round(size * unit_price)"}},
+		}
+		rules, err := table.Rules()
+		if assert.Nil(t, err) {
+			assert.Equal(t, []string{"size", "unit_price"}, rules[0].Input)
+		}
+	})
+
+	tc := []struct {
+		Name     string
+		Input    string
+		CSV      bool
+		Line     int
+		Column   int
+		Expected string
+	}{
+		{Name: "csv without output", Input: "size,label\n> 5,'big'\n", CSV: true, Line: 1, Expected: "no output column"},
+		{Name: "csv input after output", Input: "out:label,size\n", CSV: true, Line: 1, Column: 2, Expected: `input column "size" after output columns`},
+		{Name: "csv wrong number of cells", Input: "size,out:label\n> 5\n", CSV: true, Line: 2, Column: 1, Expected: "wrong number of fields"},
+		{Name: "csv unknown hit policy", Input: "hit_policy,last\nsize,out:label\n", CSV: true, Line: 1, Column: 2, Expected: `unknown hit policy "last"`},
+		{Name: "csv field after hit policy", Input: "hit_policy,first,size\nsize,out:label\n", CSV: true, Line: 1, Column: 3, Expected: `unexpected field "size" after the hit policy`},
+		{Name: "csv cells after hit policy", Input: "hit_policy,first\nsize,out:label\n> 5\n", CSV: true, Line: 3, Column: 1, Expected: "wrong number of fields"},
+		{Name: "json unknown field", Input: "{\n    \"hit\": \"first\"\n}", Line: 2, Column: 5, Expected: `unknown field "hit"`},
+		{Name: "json unknown hit policy", Input: `{"hit_policy": "last"}`, Line: 1, Column: 16, Expected: `unknown hit policy "last"`},
+		{Name: "json wrong number of cells", Input: `{"inputs": ["size"], "outputs": ["label"], "rows": [["> 5"]]}`, Expected: "row 1 has 1 cells, expected 2"},
+	}
+
+	for _, test := range tc {
+		t.Run(test.Name, func(t *testing.T) {
+			var err error
+			if test.CSV {
+				_, err = LoadDecisionTableCSV(strings.NewReader(test.Input))
+			} else {
+				_, err = LoadDecisionTable(strings.NewReader(test.Input))
+			}
+
+			var loadErr *LoadError
+			if !assert.True(t, errors.As(err, &loadErr)) {
+				return
+			}
+			assert.Equal(t, test.Line, loadErr.Line)
+			assert.Equal(t, test.Column, loadErr.Column)
+			assert.Contains(t, loadErr.Error(), test.Expected)
+		})
+	}
+}
@@ -0,0 +1,659 @@
+package fished
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ErrUnsupportedDMN is wrapped by the errors of LoadDMN reporting DMN constructs which have no rule equivalent
+var ErrUnsupportedDMN = errors.New("fished: unsupported DMN construct")
+
+type (
+	// dmnDecision is a decision of a DMN model, its logic is either a decision table or a literal expression
+	dmnDecision struct {
+		ID                      string            `xml:"id,attr"`
+		Name                    string            `xml:"name,attr"`
+		Description             string            `xml:"description"`
+		InformationRequirements []dmnRequirement  `xml:"informationRequirement"`
+		KnowledgeRequirements   []dmnElement      `xml:"knowledgeRequirement"`
+		DecisionTable           *dmnDecisionTable `xml:"decisionTable"`
+		LiteralExpression       *dmnText          `xml:"literalExpression"`
+		Other                   []dmnElement      `xml:",any"`
+		offset                  int64
+	}
+
+	dmnInputData struct {
+		ID   string `xml:"id,attr"`
+		Name string `xml:"name,attr"`
+	}
+
+	dmnRequirement struct {
+		RequiredDecision *dmnHref `xml:"requiredDecision"`
+		RequiredInput    *dmnHref `xml:"requiredInput"`
+	}
+
+	dmnHref struct {
+		Href string `xml:"href,attr"`
+	}
+
+	dmnDecisionTable struct {
+		HitPolicy   string `xml:"hitPolicy,attr"`
+		Aggregation string `xml:"aggregation,attr"`
+		Inputs      []struct {
+			Expression dmnText `xml:"inputExpression"`
+		} `xml:"input"`
+		Outputs []struct {
+			Name    string   `xml:"name,attr"`
+			Default *dmnText `xml:"defaultOutputEntry"`
+		} `xml:"output"`
+		Rules []struct {
+			InputEntries  []dmnText `xml:"inputEntry"`
+			OutputEntries []dmnText `xml:"outputEntry"`
+		} `xml:"rule"`
+	}
+
+	dmnText struct {
+		Language string `xml:"expressionLanguage,attr"`
+		Text     string `xml:"text"`
+	}
+
+	dmnElement struct {
+		XMLName xml.Name
+	}
+
+	// dmnModel resolves the references between the elements of a DMN model
+	dmnModel struct {
+		// facts lists the facts produced by each element, by ID
+		facts map[string][]string
+		// names are the fact names FEEL expressions may refer to, longest first
+		names []string
+	}
+)
+
+var (
+	// dmnIgnored are the children of a decision which do not change how it is evaluated
+	dmnIgnored = map[string]struct{}{
+		"description": {}, "question": {}, "allowedAnswers": {}, "variable": {}, "extensionElements": {},
+		"authorityRequirement": {}, "supportedObjective": {}, "impactedPerformanceIndicator": {},
+		"decisionMaker": {}, "decisionOwner": {}, "usingProcess": {}, "usingTask": {},
+	}
+
+	// dmnHitPolicies maps the DMN hit policies to those of decision tables, RULE ORDER returns every output like COLLECT
+	dmnHitPolicies = map[string]HitPolicy{
+		"":           HitUnique,
+		"UNIQUE":     HitUnique,
+		"FIRST":      HitFirst,
+		"ANY":        HitAny,
+		"COLLECT":    HitCollect,
+		"RULE ORDER": HitCollect,
+	}
+
+	identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	bracketPattern    = regexp.MustCompile(`^\[[^\]]*\]$`)
+	rangePattern      = regexp.MustCompile(`^([\[\](])\s*(.+?)\s*\.\.\s*(.+?)\s*([\[\])])$`)
+
+	// feelKeywords are the FEEL keywords without govaluate equivalent
+	feelKeywords = map[string]struct{}{
+		"if": {}, "then": {}, "else": {}, "for": {}, "return": {}, "some": {}, "every": {}, "satisfies": {},
+		"in": {}, "between": {}, "instance": {}, "function": {}, "null": {},
+	}
+
+	// feelFunctions are the FEEL builtin functions without govaluate equivalent, a name comes before the names it starts
+	feelFunctions = []string{
+		"date and time", "years and months duration", "day of week", "day of year", "week of year", "month of year",
+		"string length", "substring before", "substring after", "upper case", "lower case", "starts with", "ends with",
+		"list contains", "insert before", "index of", "distinct values", "get value", "get entries", "is defined",
+		"date", "time", "duration", "now", "today", "substring", "contains", "matches", "replace", "split",
+		"number", "string", "count", "min", "max", "sum", "mean", "median", "stddev", "mode", "all", "any",
+		"sublist", "append", "concatenate", "remove", "reverse", "union", "flatten", "product", "sort",
+		"decimal", "floor", "ceiling", "abs", "modulo", "sqrt", "log", "exp", "odd", "even",
+	}
+)
+
+// LoadDMN reads the decision requirement graph of a DMN 1.x model into a rule set file. Decision tables become one
+// rule per output column and literal expressions a single rule, FEEL unary tests and simple FEEL expressions are
+// translated into govaluate expressions. The input of a rule lists the facts of the inputs and decisions its decision
+// requires, its output is the name of the decision, or the name of each output column of multi-output tables.
+// The target is set when a single decision is required by no other.
+//
+// Constructs without equivalent, such as business knowledge models, contexts, FEEL builtin functions
+// or the PRIORITY hit policy, are reported by a *LoadError wrapping ErrUnsupportedDMN.
+func LoadDMN(r io.Reader) (*RuleSetFile, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	model := &dmnModel{
+		facts: make(map[string][]string),
+	}
+	f := &RuleSetFile{FormatVersion: FormatVersion}
+	var decisions []*dmnDecision
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	root := false
+	for {
+		offset := dec.InputOffset()
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, xmlLoadError(data, offset, err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				if token.Name.Local != "definitions" {
+					return nil, newLoadError(data, offset, fmt.Errorf("root element is %s, not a DMN definitions element", token.Name.Local))
+				}
+				f.Name = attr(token, "name")
+				root = true
+				depth++
+				continue
+			}
+
+			switch token.Name.Local {
+			case "inputData":
+				var input dmnInputData
+				if err := dec.DecodeElement(&input, &token); err != nil {
+					return nil, xmlLoadError(data, offset, err)
+				}
+				model.facts[input.ID] = []string{input.Name}
+			case "decision":
+				decision := &dmnDecision{offset: offset}
+				if err := dec.DecodeElement(decision, &token); err != nil {
+					return nil, xmlLoadError(data, offset, err)
+				}
+				decisions = append(decisions, decision)
+				model.facts[decision.ID] = decision.outputs()
+			case "import":
+				return nil, newLoadError(data, offset, fmt.Errorf("%w: import of %q", ErrUnsupportedDMN, attr(token, "locationURI")))
+			default:
+				if err := dec.Skip(); err != nil {
+					return nil, xmlLoadError(data, offset, err)
+				}
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	if !root {
+		return nil, &LoadError{Line: 1, Err: errors.New("missing DMN definitions element")}
+	}
+
+	for _, facts := range model.facts {
+		model.names = append(model.names, facts...)
+	}
+	sort.Slice(model.names, func(a, b int) bool {
+		return len(model.names[a]) > len(model.names[b])
+	})
+
+	required := make(map[string]struct{})
+	for _, decision := range decisions {
+		rules, err := model.rules(decision)
+		if err != nil {
+			return nil, newLoadError(data, decision.offset, fmt.Errorf("decision %q: %w", decision.Name, err))
+		}
+		f.Rules = append(f.Rules, rules...)
+		for _, requirement := range decision.InformationRequirements {
+			if requirement.RequiredDecision != nil {
+				required[strings.TrimPrefix(requirement.RequiredDecision.Href, "#")] = struct{}{}
+			}
+		}
+	}
+
+	var top []*dmnDecision
+	for _, decision := range decisions {
+		if _, ok := required[decision.ID]; !ok {
+			top = append(top, decision)
+		}
+	}
+	if len(top) == 1 && len(model.facts[top[0].ID]) == 1 {
+		f.Target = model.facts[top[0].ID][0]
+	}
+	return f, nil
+}
+
+// LoadDMNFile reads the DMN model at path like LoadDMN
+func LoadDMNFile(path string) (*RuleSetFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	f, err := LoadDMN(file)
+	var loadErr *LoadError
+	if errors.As(err, &loadErr) {
+		loadErr.Path = path
+	}
+	return f, err
+}
+
+// xmlLoadError locates err at the line of XML syntax errors, at offset otherwise
+func xmlLoadError(data []byte, offset int64, err error) *LoadError {
+	var syntaxErr *xml.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return &LoadError{Line: syntaxErr.Line, Err: err}
+	}
+	return newLoadError(data, offset, err)
+}
+
+// outputs returns the facts produced by the decision, its name unless its table has several outputs
+func (d *dmnDecision) outputs() []string {
+	if d.DecisionTable == nil || len(d.DecisionTable.Outputs) < 2 {
+		return []string{d.Name}
+	}
+	outputs := make([]string, len(d.DecisionTable.Outputs))
+	for i, output := range d.DecisionTable.Outputs {
+		outputs[i] = output.Name
+	}
+	return outputs
+}
+
+// rules translates the logic of a decision into rules taking the facts of its requirements as input
+func (m *dmnModel) rules(d *dmnDecision) ([]Rule, error) {
+	for _, other := range d.Other {
+		if _, ok := dmnIgnored[other.XMLName.Local]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedDMN, other.XMLName.Local)
+		}
+	}
+	if len(d.KnowledgeRequirements) > 0 {
+		return nil, fmt.Errorf("%w: business knowledge model", ErrUnsupportedDMN)
+	}
+
+	var inputs []string
+	for _, requirement := range d.InformationRequirements {
+		href := requirement.RequiredInput
+		if href == nil {
+			href = requirement.RequiredDecision
+		}
+		if href == nil {
+			continue
+		}
+		if !strings.HasPrefix(href.Href, "#") {
+			return nil, fmt.Errorf("%w: requirement %q from another model", ErrUnsupportedDMN, href.Href)
+		}
+		facts, ok := m.facts[strings.TrimPrefix(href.Href, "#")]
+		if !ok {
+			return nil, fmt.Errorf("requirement %q not found", href.Href)
+		}
+		inputs = append(inputs, facts...)
+	}
+
+	var rules []Rule
+	switch {
+	case d.DecisionTable != nil:
+		table, err := m.decisionTable(d)
+		if err != nil {
+			return nil, err
+		}
+		rules, err = table.rules(m.unaryTests)
+		if err != nil {
+			return nil, err
+		}
+	case d.LiteralExpression != nil:
+		expression, err := m.expression(d.LiteralExpression)
+		if err != nil {
+			return nil, err
+		}
+		rules = []Rule{{
+			ID:          d.ID,
+			Name:        d.Name,
+			Description: strings.TrimSpace(d.Description),
+			Output:      d.Name,
+			Expression:  expression,
+		}}
+	default:
+		return nil, errors.New("no decision table nor literal expression")
+	}
+
+	// the rules wait for every requirement, like the decision, which must require every fact it uses
+	for i := range rules {
+		used, err := expressionInputs(rules[i].Expression)
+		if err != nil {
+			return nil, err
+		}
+		for _, fact := range used {
+			if !contains(inputs, fact) {
+				return nil, fmt.Errorf("%q is used without information requirement", fact)
+			}
+		}
+		rules[i].Input = append([]string{}, inputs...)
+	}
+	return rules, nil
+}
+
+// decisionTable translates the table of a decision, input cells are left as FEEL unary tests for unaryTests
+func (m *dmnModel) decisionTable(d *dmnDecision) (*DecisionTable, error) {
+	dt := d.DecisionTable
+	policy, ok := dmnHitPolicies[dt.HitPolicy]
+	if !ok {
+		return nil, fmt.Errorf("%w: hit policy %s", ErrUnsupportedDMN, dt.HitPolicy)
+	}
+	if dt.Aggregation != "" {
+		return nil, fmt.Errorf("%w: %s aggregation", ErrUnsupportedDMN, dt.Aggregation)
+	}
+
+	table := &DecisionTable{
+		ID:          d.ID,
+		Name:        d.Name,
+		Description: strings.TrimSpace(d.Description),
+		HitPolicy:   policy,
+		Outputs:     d.outputs(),
+	}
+	for i, input := range dt.Inputs {
+		expression, err := m.expression(&input.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i+1, err)
+		}
+		// unary tests are appended to the input expression, which is kept whole
+		if !identifierPattern.MatchString(expression) && !bracketPattern.MatchString(expression) {
+			expression = "(" + expression + ")"
+		}
+		table.Inputs = append(table.Inputs, expression)
+	}
+	for _, output := range dt.Outputs {
+		if output.Default != nil {
+			return nil, fmt.Errorf("%w: default output entry", ErrUnsupportedDMN)
+		}
+	}
+
+	for i, rule := range dt.Rules {
+		if len(rule.InputEntries) != len(dt.Inputs) || len(rule.OutputEntries) != len(dt.Outputs) {
+			return nil, fmt.Errorf("rule %d has %d input and %d output entries, expected %d and %d",
+				i+1, len(rule.InputEntries), len(rule.OutputEntries), len(dt.Inputs), len(dt.Outputs))
+		}
+		row := make([]string, 0, len(rule.InputEntries)+len(rule.OutputEntries))
+		for _, entry := range rule.InputEntries {
+			if err := checkLanguage(&entry); err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i+1, err)
+			}
+			row = append(row, entry.Text)
+		}
+		for _, entry := range rule.OutputEntries {
+			expression, err := m.expression(&entry)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i+1, err)
+			}
+			row = append(row, expression)
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table, nil
+}
+
+// unaryTests translates FEEL unary tests on column: "-", comparisons, ranges such as [1..10[, lists and not(...)
+func (m *dmnModel) unaryTests(column, cell string) (string, error) {
+	cell = strings.TrimSpace(cell)
+	if cell == "" || cell == "-" {
+		return "", nil
+	}
+	if strings.HasPrefix(cell, "not(") && strings.HasSuffix(cell, ")") {
+		test, err := m.unaryTests(column, cell[len("not("):len(cell)-1])
+		if err != nil || test == "" {
+			return test, err
+		}
+		return "!" + test, nil
+	}
+	if strings.Contains(cell, "?") {
+		return "", fmt.Errorf("%w: ? in unary test %q", ErrUnsupportedDMN, cell)
+	}
+
+	var tests []string
+	for _, item := range splitUnaryTests(cell) {
+		test, err := m.unaryTest(column, item)
+		if err != nil {
+			return "", err
+		}
+		tests = append(tests, test)
+	}
+	if _, err := parseCell(strings.Join(tests, " || ")); err != nil {
+		return "", err
+	}
+	return "(" + strings.Join(tests, " || ") + ")", nil
+}
+
+// unaryTest translates a single FEEL unary test on column
+func (m *dmnModel) unaryTest(column, item string) (string, error) {
+	if match := rangePattern.FindStringSubmatch(item); match != nil {
+		low, err := m.feel(match[2])
+		if err != nil {
+			return "", err
+		}
+		high, err := m.feel(match[3])
+		if err != nil {
+			return "", err
+		}
+		lowOp, highOp := ">=", "<="
+		if match[1] != "[" {
+			lowOp = ">"
+		}
+		if match[4] != "]" {
+			highOp = "<"
+		}
+		return column + " " + lowOp + " " + low + " && " + column + " " + highOp + " " + high, nil
+	}
+
+	for _, op := range []string{"<=", ">=", "<", ">"} {
+		if strings.HasPrefix(item, op) {
+			value, err := m.feel(strings.TrimPrefix(item, op))
+			if err != nil {
+				return "", err
+			}
+			return column + " " + op + " " + value, nil
+		}
+	}
+
+	value, err := m.feel(item)
+	if err != nil {
+		return "", err
+	}
+	return column + " == " + value, nil
+}
+
+// expression translates a FEEL expression of the model
+func (m *dmnModel) expression(text *dmnText) (string, error) {
+	if err := checkLanguage(text); err != nil {
+		return "", err
+	}
+	expression, err := m.feel(text.Text)
+	if err != nil {
+		return "", err
+	}
+	if _, err := parseCell(expression); err != nil {
+		return "", fmt.Errorf("expression %q: %v", text.Text, err)
+	}
+	return expression, nil
+}
+
+// feel translates the subset of FEEL shared with govaluate: literals, arithmetic, comparisons, and, or, not
+// and function calls. Fact names may hold spaces as in FEEL.
+func (m *dmnModel) feel(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", errors.New("empty expression")
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '"':
+			end := stringEnd(text, i)
+			if end < 0 {
+				return "", fmt.Errorf("unterminated string in %q", text)
+			}
+			out.WriteString(text[i:end])
+			i = end
+			continue
+		case isNameStart(c) && (i == 0 || !isNamePart(text[i-1])):
+			if name := functionAt(text, i); name != "" {
+				return "", fmt.Errorf("%w: function %s in %q", ErrUnsupportedDMN, name, text)
+			}
+			if name := m.nameAt(text, i); name != "" {
+				out.WriteString(variableName(name))
+				i += len(name)
+				continue
+			}
+			end := i
+			for end < len(text) && isNamePart(text[end]) {
+				end++
+			}
+			word := text[i:end]
+			switch word {
+			case "and":
+				out.WriteString("&&")
+			case "or":
+				out.WriteString("||")
+			case "not":
+				out.WriteString("!")
+			default:
+				if _, ok := feelKeywords[word]; ok {
+					return "", fmt.Errorf("%w: %s in %q", ErrUnsupportedDMN, word, text)
+				}
+				out.WriteString(word)
+			}
+			i = end
+			continue
+		case c == '=' && (i == 0 || !strings.ContainsRune("<>!=", rune(text[i-1]))) && (i+1 == len(text) || text[i+1] != '='):
+			out.WriteString("==")
+		case c == '[' || c == '{' || strings.HasPrefix(text[i:], ".."):
+			return "", fmt.Errorf("%w: lists, contexts and ranges in %q", ErrUnsupportedDMN, text)
+		default:
+			out.WriteByte(c)
+		}
+		i++
+	}
+	return out.String(), nil
+}
+
+// nameAt returns the longest fact name starting at i and ending on a word boundary, or an empty string
+func (m *dmnModel) nameAt(text string, i int) string {
+	for _, name := range m.names {
+		end := i + len(name)
+		if strings.HasPrefix(text[i:], name) && (end == len(text) || !isNamePart(text[end])) {
+			return name
+		}
+	}
+	return ""
+}
+
+// functionAt returns the FEEL builtin function called at i, or an empty string
+func functionAt(text string, i int) string {
+	for _, name := range feelFunctions {
+		end := i + len(name)
+		if !strings.HasPrefix(text[i:], name) || end < len(text) && isNamePart(text[end]) {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimLeft(text[end:], " \t"), "(") {
+			return name
+		}
+	}
+	return ""
+}
+
+// stringEnd returns the index following the FEEL string starting at i, honouring backslash escapes,
+// or -1 when it is not terminated
+func stringEnd(text string, i int) int {
+	for end := i + 1; end < len(text); end++ {
+		switch text[end] {
+		case '\\':
+			end++
+		case '"':
+			return end + 1
+		}
+	}
+	return -1
+}
+
+// splitUnaryTests splits a list of FEEL unary tests on the commas outside of strings, calls and ranges.
+// Unlike splitList it knows a range may be closed by any bracket, as in [1..5[ or ]1..5].
+func splitUnaryTests(cell string) []string {
+	var values []string
+	depth, start := 0, 0
+	inRange := false
+	for i := 0; i < len(cell); i++ {
+		c := cell[i]
+		switch {
+		case c == '"':
+			if end := stringEnd(cell, i); end > 0 {
+				i = end - 1
+			} else {
+				i = len(cell)
+			}
+		case depth == 0 && !inRange && strings.ContainsRune("[](", rune(c)) && strings.TrimSpace(cell[start:i]) == "" && isRangeAt(cell, i):
+			inRange = true
+		case depth == 0 && inRange && strings.ContainsRune("[])", rune(c)):
+			inRange = false
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case c == ',' && depth == 0 && !inRange:
+			values = append(values, strings.TrimSpace(cell[start:i]))
+			start = i + 1
+		}
+	}
+	return append(values, strings.TrimSpace(cell[start:]))
+}
+
+// isRangeAt reports whether the unary test starting at i is a range, its .. comes before the next comma
+func isRangeAt(cell string, i int) bool {
+	dots := strings.Index(cell[i:], "..")
+	comma := strings.IndexByte(cell[i:], ',')
+	return dots > 0 && (comma < 0 || dots < comma)
+}
+
+// checkLanguage rejects expressions written in another language than FEEL
+func checkLanguage(text *dmnText) error {
+	if text.Language != "" && !strings.Contains(strings.ToUpper(text.Language), "FEEL") {
+		return fmt.Errorf("%w: expression language %q", ErrUnsupportedDMN, text.Language)
+	}
+	return nil
+}
+
+// variableName escapes fact names which are not identifiers, as govaluate does with brackets
+func variableName(name string) string {
+	if identifierPattern.MatchString(name) {
+		return name
+	}
+	return "[" + name + "]"
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isNamePart(c byte) bool {
+	return isNameStart(c) || c >= '0' && c <= '9'
+}
+
+func attr(element xml.StartElement, name string) string {
+	for _, a := range element.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package fished
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadDMN(t *testing.T) {
+	f, err := LoadDMNFile("./test/tc10.dmn")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "eligibility", f.Name)
+	assert.Equal(t, "isEligible", f.Target)
+	if !assert.Len(t, f.Rules, 2) {
+		return
+	}
+	assert.Equal(t, Rule{
+		ID:          "d_partner",
+		Name:        "is_partner",
+		Description: "Accounts of our partners",
+		Input:       []string{"Account Partner"},
+		Output:      "is_partner",
+		Expression:  `[Account Partner] == "hello" || [Account Partner] == "hi"`,
+	}, f.Rules[0])
+	assert.Equal(t, "d_eligible", f.Rules[1].ID)
+	assert.Equal(t, []string{"is_partner", "account_region", "account_age"}, f.Rules[1].Input)
+	assert.Equal(t, "isEligible", f.Rules[1].Output)
+
+	rs, err := f.Compile(nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	e := New()
+	defer e.Close()
+	e.SetRuleSet(rs)
+
+	tc := []struct {
+		Name     string
+		Facts    map[string]interface{}
+		Expected interface{}
+	}{
+		{Name: "partner in range", Facts: map[string]interface{}{"Account Partner": "hi", "account_region": "SG", "account_age": 65}, Expected: true},
+		{Name: "partner out of range", Facts: map[string]interface{}{"Account Partner": "hi", "account_region": "ID", "account_age": 70}, Expected: false},
+		{Name: "partner elsewhere", Facts: map[string]interface{}{"Account Partner": "hello", "account_region": "MY", "account_age": 21}, Expected: true},
+		{Name: "not a partner", Facts: map[string]interface{}{"Account Partner": "other", "account_region": "ID", "account_age": 30}, Expected: false},
+	}
+
+	for _, test := range tc {
+		t.Run(test.Name, func(t *testing.T) {
+			res, errs := e.Evaluate(test.Facts, "isEligible")
+			assert.Nil(t, errs)
+			assert.Equal(t, test.Expected, res)
+		})
+	}
+}
+
+func TestLoadDMNErrors(t *testing.T) {
+	const definitions = `<definitions xmlns="http://www.omg.org/spec/DMN/20180521/MODEL/" name="test">
+  <inputData id="i_age" name="age"/>
+  %s
+</definitions>`
+
+	tc := []struct {
+		Name        string
+		Decision    string
+		Line        int
+		Unsupported bool
+		Expected    string
+	}{
+		{
+			Name:        "hit policy",
+			Decision:    `<decision id="d" name="d"><decisionTable hitPolicy="PRIORITY"/></decision>`,
+			Line:        3,
+			Unsupported: true,
+			Expected:    `decision "d": fished: unsupported DMN construct: hit policy PRIORITY`,
+		},
+		{
+			Name:        "business knowledge model",
+			Decision:    `<decision id="d" name="d"><knowledgeRequirement><requiredKnowledge href="#bkm"/></knowledgeRequirement><literalExpression><text>1</text></literalExpression></decision>`,
+			Line:        3,
+			Unsupported: true,
+			Expected:    "business knowledge model",
+		},
+		{
+			Name:        "context",
+			Decision:    `<decision id="d" name="d"><context/></decision>`,
+			Line:        3,
+			Unsupported: true,
+			Expected:    "unsupported DMN construct: context",
+		},
+		{
+			Name:        "feel keyword",
+			Decision:    `<decision id="d" name="d"><informationRequirement><requiredInput href="#i_age"/></informationRequirement><literalExpression><text>if age > 18 then "adult" else "minor"</text></literalExpression></decision>`,
+			Line:        3,
+			Unsupported: true,
+			Expected:    `if in "if age > 18 then \"adult\" else \"minor\""`,
+		},
+		{
+			Name:        "feel function",
+			Decision:    `<decision id="d" name="d"><informationRequirement><requiredInput href="#i_age"/></informationRequirement><literalExpression><text>string length(age) > 2</text></literalExpression></decision>`,
+			Line:        3,
+			Unsupported: true,
+			Expected:    `function string length in "string length(age) > 2"`,
+		},
+		{
+			Name:     "missing requirement",
+			Decision: `<decision id="d" name="d"><literalExpression><text>age > 18</text></literalExpression></decision>`,
+			Line:     3,
+			Expected: `"age" is used without information requirement`,
+		},
+		{
+			Name:     "xml syntax",
+			Decision: `<decision id="d" name="d">`,
+			Line:     4,
+			Expected: "XML syntax error",
+		},
+	}
+
+	for _, test := range tc {
+		t.Run(test.Name, func(t *testing.T) {
+			f, err := LoadDMN(strings.NewReader(strings.Replace(definitions, "%s", test.Decision, 1)))
+			assert.Nil(t, f)
+			assert.Equal(t, test.Unsupported, errors.Is(err, ErrUnsupportedDMN))
+
+			var loadErr *LoadError
+			if !assert.True(t, errors.As(err, &loadErr)) {
+				return
+			}
+			assert.Equal(t, test.Line, loadErr.Line)
+			assert.Contains(t, loadErr.Error(), test.Expected)
+		})
+	}
+}
+
+func TestDMNUnaryTests(t *testing.T) {
+	m := &dmnModel{names: []string{"age"}}
+
+	tc := []struct {
+		Name     string
+		Cell     string
+		Expected string
+	}{
+		{Name: "half open ranges", Cell: "[1..5[, [10..20]", Expected: "(age >= 1 && age < 5 || age >= 10 && age <= 20)"},
+		{Name: "ranges open at the start", Cell: "]1..5], ]10..20[, 30", Expected: "(age > 1 && age <= 5 || age > 10 && age < 20 || age == 30)"},
+		{Name: "parenthesized ranges", Cell: "(1..5), (10..20]", Expected: "(age > 1 && age < 5 || age > 10 && age <= 20)"},
+		{Name: "escaped quotes", Cell: `"a\"b", "c,d"`, Expected: `(age == "a\"b" || age == "c,d")`},
+	}
+
+	for _, test := range tc {
+		t.Run(test.Name, func(t *testing.T) {
+			res, err := m.unaryTests("age", test.Cell)
+			assert.Nil(t, err)
+			assert.Equal(t, test.Expected, res)
+		})
+	}
+}
@@ -1,14 +1,16 @@
 package fished
 
 import (
+	"context"
 	"errors"
 	"runtime"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hooqtv/fished/pool"
 	"github.com/knetic/govaluate"
-	"github.com/patrickmn/go-cache"
 )
 
 var (
@@ -20,50 +22,111 @@ var (
 
 	// DefaultRuleLength ...
 	DefaultRuleLength = 100
+
+	// ErrTimeout is returned when a run is aborted because its context deadline exceeded,
+	// the error also matches context.DeadlineExceeded
+	ErrTimeout = errors.New("fished: run deadline exceeded")
+
+	// ErrCanceled is returned when a run is aborted because its context was canceled,
+	// the error also matches the error of the context
+	ErrCanceled = errors.New("fished: run canceled")
+
+	// ErrClosed is returned when running an engine which has been closed
+	ErrClosed = errors.New("fished: engine closed")
+
+	// ErrNilRuleSet is returned when setting a nil rule set
+	ErrNilRuleSet = errors.New("fished: nil rule set")
 )
 
 type (
 	// Engine core of the machine
 	Engine struct {
-		InitialFacts  map[string]interface{}
-		Rules         []Rule
-		RuleFunctions map[string]govaluate.ExpressionFunction
-		RuleCache     *cache.Cache
-		RunLock       sync.RWMutex
-		RuntimePool   *pool.ReferenceCountedPool
+		RuntimePool *pool.ReferenceCountedPool
+		current     atomic.Value
+		writeLock   sync.Mutex
+		lifecycle   sync.Mutex
+		closed      bool
+		runs        sync.WaitGroup
+		scheduler   *scheduler
 	}
 
-	// Rule is struct for rule in fished
+	// Rule is struct for rule in fished.
+	// Priority (salience) is optional: within a wave higher priority rules fire first,
+	// and by default they override rules with lower priority producing the same output.
+	// OnError overrides the error policy of the engine for the rule, Fallback is the output used by ErrorFallback.
+	// ID is optional but unique within a rule set, it names the rule in errors, traces and stats whatever its index.
 	Rule struct {
-		Input      []string `json:"input"`
-		Output     string   `json:"output"`
-		Expression string   `json:"expression"`
+		ID          string                 `json:"id,omitempty" yaml:"id,omitempty" toml:"id,omitempty"`
+		Name        string                 `json:"name,omitempty" yaml:"name,omitempty" toml:"name,omitempty"`
+		Description string                 `json:"description,omitempty" yaml:"description,omitempty" toml:"description,omitempty"`
+		Input       []string               `json:"input" yaml:"input" toml:"input"`
+		Output      string                 `json:"output" yaml:"output" toml:"output"`
+		Expression  string                 `json:"expression" yaml:"expression" toml:"expression"`
+		Priority    int                    `json:"priority,omitempty" yaml:"priority,omitempty" toml:"priority,omitzero"`
+		OnError     ErrorPolicy            `json:"on_error,omitempty" yaml:"on_error,omitempty" toml:"on_error,omitzero"`
+		Fallback    interface{}            `json:"fallback,omitempty" yaml:"fallback,omitempty" toml:"fallback,omitempty"`
+		Tags        []string               `json:"tags,omitempty" yaml:"tags,omitempty" toml:"tags,omitempty"`
+		Metadata    map[string]interface{} `json:"metadata,omitempty" yaml:"metadata,omitempty" toml:"metadata,omitempty"`
+		// Comment is written as a comment above the rule in YAML and TOML
+		Comment string `json:"comment,omitempty" yaml:"-" toml:"-"`
 	}
 
 	// RuleFunction if type defined for rule function
 	RuleFunction func(...interface{}) (interface{}, error)
 
-	// Runtime is an struct for each time Engine.Run() is called
+	// Runtime is an struct for each time Engine.Run() is called.
+	// Its jobs go through the engine scheduler, a Runtime from NewRuntime can only evaluate jobs with Evaluate.
 	Runtime struct {
 		pool.ReferenceCounter
 		Facts      map[string]interface{}
-		JobCh      chan *Job
-		ResultCh   chan *EvalResult
 		UsedRule   map[int]struct{}
 		FactsMutex sync.RWMutex
+		missing    []int
+		owners     map[string]int
+		// unsettled counts the producers of a fact which did not fire yet, held are the facts known
+		// before their last producer fired, their dependents wait for the final value
+		unsettled map[string]int
+		held      map[string]struct{}
+		pending   int
+		window    int
+		jobCh     chan *Job
+		resultCh  chan *EvalResult
 	}
 
 	// Job struct
 	Job struct {
+		runtime          *Runtime
+		Context          context.Context
+		Rule             int
+		Inputs           []string
 		Output           string
 		ParsedExpression *govaluate.EvaluableExpression
+		Trace            bool
+	}
+
+	// RunOption configures a single run
+	RunOption func(*runConfig)
+
+	runConfig struct {
+		goalDirected bool
+		trace        *Trace
+		diagnose     bool
+		facts        map[string]interface{}
+		concurrency  int
+		unordered    bool
+		targets      []string
+		derived      bool
 	}
 
-	// EvalResult is evaluation Result
+	// EvalResult is evaluation Result, Phase tells where Error happened
 	EvalResult struct {
-		Key   string
-		Value interface{}
-		Error error
+		Rule     int
+		Key      string
+		Value    interface{}
+		Error    error
+		Phase    Phase
+		Inputs   map[string]interface{}
+		Duration time.Duration
 	}
 )
 
@@ -76,8 +139,6 @@ func New() *Engine {
 func NewWithCustomWorkerSize(worker int) *Engine {
 	var workerSize int
 
-	c := cache.New(24*time.Hour, 1*time.Hour)
-
 	if worker == DefaultWorker {
 		numCPU := runtime.NumCPU()
 		if numCPU <= 2 {
@@ -93,208 +154,402 @@ func NewWithCustomWorkerSize(worker int) *Engine {
 		workerSize = 1
 	}
 
-	return &Engine{
-		RuleCache: c,
-		RuntimePool: pool.NewReferenceCountedPool(
-			func(counter pool.ReferenceCounter) pool.ReferenceCountable {
-				br := new(Runtime)
-				br.JobCh = make(chan *Job, DefaultRuleLength)
-				br.ResultCh = make(chan *EvalResult, DefaultRuleLength)
-				br.UsedRule = make(map[int]struct{})
-				br.ReferenceCounter = counter
-
-				for i := 0; i < workerSize; i++ {
-					go func() {
-						for job := range br.JobCh {
-							br.Evaluate(job, br.ResultCh)
-						}
-					}()
-				}
-				return br
-			}, func(i interface{}) error {
-				obj, ok := i.(*Runtime)
-				if !ok {
-					return errors.New("Illegal object passed")
-				}
-				obj.Reset()
-				return nil
-			}),
+	e := &Engine{
+		scheduler: newScheduler(workerSize, DefaultRuleLength),
 	}
+	e.current.Store(&snapshot{
+		ruleSet:   &RuleSet{},
+		conflicts: conflictConfig{strategy: ConflictPriority},
+	})
+	e.RuntimePool = pool.NewReferenceCountedPool(
+		func(counter pool.ReferenceCounter) pool.ReferenceCountable {
+			br := new(Runtime)
+			br.jobCh = e.scheduler.jobCh
+			br.window = e.scheduler.window
+			// a run never has more than window jobs out, so workers never block on sending a result
+			br.resultCh = make(chan *EvalResult, br.window)
+			br.UsedRule = make(map[int]struct{})
+			br.owners = make(map[string]int)
+			br.unsettled = make(map[string]int)
+			br.held = make(map[string]struct{})
+			br.ReferenceCounter = counter
+			return br
+		}, func(i interface{}) error {
+			obj, ok := i.(*Runtime)
+			if !ok {
+				return errors.New("Illegal object passed")
+			}
+			obj.Reset()
+			return nil
+		})
+	return e
 }
 
-// Set all of engine attibutes in one single function
-func (e *Engine) Set(facts map[string]interface{}, rules []Rule, ruleFunction map[string]RuleFunction) error {
-	var err error
-
-	err = e.SetFacts(facts)
-	if err != nil {
-		return err
+// Close waits for in-flight runs to finish, then stops the engine workers.
+// Runs started after Close return ErrClosed.
+func (e *Engine) Close() error {
+	e.lifecycle.Lock()
+	if e.closed {
+		e.lifecycle.Unlock()
+		return nil
 	}
+	e.closed = true
+	e.lifecycle.Unlock()
 
-	err = e.SetRules(rules)
-	if err != nil {
-		return err
-	}
+	e.runs.Wait()
+	e.scheduler.close()
+	return nil
+}
 
-	err = e.SetRuleFunctions(ruleFunction)
-	if err != nil {
-		return err
+// acquire registers a new run, it fails once the engine is closed
+func (e *Engine) acquire() bool {
+	e.lifecycle.Lock()
+	defer e.lifecycle.Unlock()
+	if e.closed {
+		return false
 	}
+	e.runs.Add(1)
+	atomic.AddInt64(&e.scheduler.active, 1)
+	return true
+}
 
+// done unregisters a run once its runtime is back in the pool
+func (e *Engine) done() {
+	atomic.AddInt64(&e.scheduler.active, -1)
+	e.runs.Done()
+}
+
+// Set all of engine attibutes in one single function, runs see either all or none of the changes
+func (e *Engine) Set(facts map[string]interface{}, rules []Rule, ruleFunction map[string]RuleFunction) error {
+	e.update(func(s *snapshot) {
+		s.setFacts(facts)
+		s.setRules(rules)
+		s.setRuleFunctions(ruleFunction)
+		s.compile()
+	})
 	return nil
 }
 
 // SetFacts will set current engine with initial facts (replace the old one)
 func (e *Engine) SetFacts(facts map[string]interface{}) error {
-	e.RunLock.Lock()
-	defer e.RunLock.Unlock()
-	e.InitialFacts = make(map[string]interface{})
-
-	for key, value := range facts {
-		e.InitialFacts[key] = value
-	}
+	e.update(func(s *snapshot) {
+		s.setFacts(facts)
+	})
 	return nil
 }
 
 // SetRules will set current engine with rules
 func (e *Engine) SetRules(rules []Rule) error {
-	e.RunLock.Lock()
-	defer e.RunLock.Unlock()
-	e.Rules = make([]Rule, len(rules))
-	copy(e.Rules, rules)
-	e.RuleCache.Flush()
+	e.update(func(s *snapshot) {
+		s.setRules(rules)
+		s.compile()
+	})
+	return nil
+}
+
+// SetRuleSet will set current engine with an already compiled rule set, including its rule functions
+func (e *Engine) SetRuleSet(rs *RuleSet) error {
+	if rs == nil {
+		return ErrNilRuleSet
+	}
+	e.update(func(s *snapshot) {
+		s.rules = rs.rules
+		s.functions = rs.functions
+		s.ruleSet = rs
+		s.compileErr = nil
+	})
 	return nil
 }
 
 // SetRuleFunctions will set current engine with Expression Functions
 func (e *Engine) SetRuleFunctions(ruleFunctions map[string]RuleFunction) error {
-	e.RunLock.Lock()
-	defer e.RunLock.Unlock()
-	e.RuleFunctions = make(map[string]govaluate.ExpressionFunction)
-
-	for key, value := range ruleFunctions {
-		e.RuleFunctions[key] = govaluate.ExpressionFunction(value)
-	}
+	e.update(func(s *snapshot) {
+		s.setRuleFunctions(ruleFunctions)
+		s.compile()
+	})
 	return nil
 }
 
-// RunDefault will execute run with default parameneter
-func (e *Engine) RunDefault() (interface{}, []error) {
-	return e.Run(DefaultTarget, DefaultWorker)
+// RunDefault will execute run with default parameneter, targeting the default target of the rule set
+func (e *Engine) RunDefault() (interface{}, MultiError) {
+	target := DefaultTarget
+	if rs := e.load().ruleSet; rs != nil {
+		target = rs.Target()
+	}
+	return e.Run(target, DefaultWorker)
 }
 
 // RunWithCustomTarget will execute run using customizable end target
-func (e *Engine) RunWithCustomTarget(target string) (interface{}, []error) {
+func (e *Engine) RunWithCustomTarget(target string) (interface{}, MultiError) {
 	return e.Run(target, 0)
 }
 
 // Run will execute rule and facts to get the result
 // DEPRICATION NOTICE : worker param is depricated since it has been moved to engine struct
-func (e *Engine) Run(target string, worker int) (interface{}, []error) {
-	var endTarget string
-	var errs []error
+func (e *Engine) Run(target string, worker int) (interface{}, MultiError) {
+	return e.RunContext(context.Background(), target)
+}
 
-	e.RunLock.RLock()
-	defer e.RunLock.RUnlock()
+// GoalDirected only evaluates the rules the target can be derived from, instead of every reachable rule
+func GoalDirected() RunOption {
+	return func(c *runConfig) {
+		c.goalDirected = true
+	}
+}
 
-	if target == DefaultTarget {
-		endTarget = DefaultTarget
-	} else {
-		endTarget = target
+// WithFacts supplies facts for a single run, merged over the initial facts of the engine
+func WithFacts(facts map[string]interface{}) RunOption {
+	return func(c *runConfig) {
+		c.facts = facts
 	}
+}
 
-	facts := make(map[string]interface{})
-	for key, value := range e.InitialFacts {
-		facts[key] = value
+// WithTargets reports the value of more targets in RunResult.Targets, besides the target of the run.
+// A goal directed run evaluates the rules needed by any of them.
+func WithTargets(targets ...string) RunOption {
+	return func(c *runConfig) {
+		c.targets = append(c.targets, targets...)
 	}
+}
 
-	r := e.NewRuntime(facts)
-	defer r.DecrementReferenceCount()
+// WithDerivedFacts reports every fact of the run in RunResult, split into initial and inferred facts
+func WithDerivedFacts() RunOption {
+	return func(c *runConfig) {
+		c.derived = true
+	}
+}
 
-	for {
-		var jobLength int
-		var parseRuleError bool
-		for i := range e.Rules {
-			// Check if the rule already been executed
-			if _, ok := r.UsedRule[i]; ok {
-				continue
-			}
+// Evaluate will execute rules against the given facts merged over the initial facts of the engine.
+// It does not change the engine, so one engine can serve many concurrent evaluations.
+func (e *Engine) Evaluate(facts map[string]interface{}, target string) (interface{}, MultiError) {
+	return e.EvaluateContext(context.Background(), facts, target)
+}
 
-			// copy rule into context
-			rule := e.Rules[i]
+// EvaluateContext is Evaluate aborting as soon as ctx is done
+func (e *Engine) EvaluateContext(ctx context.Context, facts map[string]interface{}, target string, opts ...RunOption) (interface{}, MultiError) {
+	return e.RunContext(ctx, target, append(opts, WithFacts(facts))...)
+}
 
-			// Verify if rule has met input requirement
-			inputLen := len(rule.Input)
-			if inputLen > 0 {
-				var ValidInput int
-				for _, input := range rule.Input {
-					if _, ok := r.Facts[input]; ok {
-						ValidInput++
-					}
-				}
-				if inputLen != ValidInput {
-					continue
-				}
-			}
+// RunContext will execute rule and facts to get the result, aborting as soon as ctx is done.
+// An aborted run returns a nil result together with ErrTimeout or ErrCanceled.
+func (e *Engine) RunContext(ctx context.Context, target string, opts ...RunOption) (interface{}, MultiError) {
+	result := e.run(ctx, target, newRunConfig(opts))
+	return result.Value, result.Errors
+}
 
-			// Check cache for parsed rule
-			parsedExpression, ok := e.RuleCache.Get(rule.Expression)
+// RunDetailed will execute rule and facts like RunContext, but returns a RunResult which
+// explains why the target was not reached when it is missing
+func (e *Engine) RunDetailed(ctx context.Context, target string, opts ...RunOption) *RunResult {
+	config := newRunConfig(opts)
+	config.diagnose = true
+	return e.run(ctx, target, config)
+}
 
-			// if not exist in cache then parse rule
-			if !ok {
-				var err error
-				parsedExpression, err = govaluate.NewEvaluableExpressionWithFunctions(rule.Expression, e.RuleFunctions)
-				if err != nil {
-					if errs == nil {
-						errs = make([]error, 0)
-					}
-					errs = append(errs, err)
-					parseRuleError = true
-					break
-				}
+func newRunConfig(opts []RunOption) runConfig {
+	var config runConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return config
+}
 
-				err = e.RuleCache.Add(rule.Expression, parsedExpression, cache.DefaultExpiration)
-				if err != nil {
-					if errs == nil {
-						errs = make([]error, 0)
-					}
-					errs = append(errs, err)
-					parseRuleError = true
-					break
-				}
-			}
+func (e *Engine) run(ctx context.Context, target string, config runConfig) *RunResult {
+	result := &RunResult{
+		Target: target,
+	}
+	targets := append([]string{target}, config.targets...)
 
-			j := &Job{
-				ParsedExpression: parsedExpression.(*govaluate.EvaluableExpression),
-				Output:           rule.Output,
-			}
+	if !e.acquire() {
+		result.Errors = append(result.Errors, ErrClosed)
+		return result
+	}
+	var r *Runtime
+	defer func() {
+		if r == nil {
+			e.done()
+			return
+		}
+		r.release(e.done)
+	}()
+
+	// everything is read from one snapshot, setters called during the run do not affect it
+	current := e.load()
+	rs := current.ruleSet
+	if rs == nil {
+		if compileErr, ok := current.compileErr.(MultiError); ok {
+			result.Errors = append(result.Errors, compileErr...)
+		}
+		return result
+	}
+	conflicts := current.conflicts
+	errorPolicy := current.errorPolicy
+
+	// nil means every rule may be evaluated
+	var needed []bool
+	if config.goalDirected {
+		needed = rs.neededBy(targets)
+	}
+
+	// rules which can not be parsed fail when they fire, unless one of those which may fire aborts the run up front
+	for i := range rs.parseErrors {
+		if needed != nil && !needed[i] {
+			continue
+		}
+		if rs.errorPolicy(i, PhaseParse, errorPolicy) == ErrorFailFast {
+			result.Errors = append(result.Errors, rs.parseErrorList(needed)...)
+			return result
+		}
+	}
+
+	// outcome of every fired rule, only kept when the run has to be explained
+	var outcomes map[int]*EvalResult
+	if config.diagnose {
+		outcomes = make(map[int]*EvalResult)
+	}
+
+	facts := make(map[string]interface{}, len(current.facts)+len(config.facts))
+	for key, value := range current.facts {
+		facts[key] = value
+	}
+	for key, value := range config.facts {
+		facts[key] = value
+	}
+
+	if config.trace != nil {
+		config.trace.reset(facts)
+	}
+	if config.derived {
+		result.Initial = make(map[string]interface{}, len(facts))
+		for key, value := range facts {
+			result.Initial[key] = value
+		}
+	}
+
+	r = e.NewRuntime(facts)
+
+	// Rules are only scheduled once their last missing input arrives, instead of rescanning every rule each wave
+	wave := r.start(rs, needed)
+	for waveNumber := 1; len(wave) > 0; waveNumber++ {
+		if err := ctx.Err(); err != nil {
+			result.Errors = append(result.Errors, contextError(err))
+			return result
+		}
+
+		jobs := make([]*Job, 0, len(wave))
+		var failed []*EvalResult
+		for _, i := range wave {
 			r.UsedRule[i] = struct{}{}
-			r.JobCh <- j
-			jobLength++
+			if err, ok := rs.parseErrors[i]; ok {
+				failed = append(failed, &EvalResult{Rule: i, Key: rs.rules[i].Output, Error: err, Phase: PhaseParse})
+				continue
+			}
+			jobs = append(jobs, &Job{
+				runtime:          r,
+				Context:          ctx,
+				Rule:             i,
+				Inputs:           rs.inputs[i],
+				ParsedExpression: rs.expressions[i],
+				Output:           rs.rules[i].Output,
+				Trace:            config.trace != nil,
+			})
 		}
 
-		if jobLength == 0 || parseRuleError {
-			break
+		results, err := r.dispatch(ctx, jobs)
+		if err == nil && ctx.Err() != nil {
+			// jobs skipped once the run was aborted failed without being evaluated, no fallback may replace them
+			err = contextError(ctx.Err())
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+			return result
 		}
+		results = append(results, failed...)
+
+		// Facts are only updated once the whole wave is done, so every rule of a wave sees the same facts.
+		// Results are applied in firing order whatever order the workers finished in.
+		sort.Slice(results, func(a, b int) bool {
+			return rs.before(results[a].Rule, results[b].Rule)
+		})
+		wave = wave[:0]
+		r.FactsMutex.Lock()
+		for _, evalResult := range results {
+			kept, abort := false, false
+			if outcomes != nil {
+				outcomes[evalResult.Rule] = evalResult
+			}
 
-		for jobs := 0; jobs < jobLength; jobs++ {
-			evalResult := <-r.ResultCh
+			if id := rs.rules[evalResult.Rule].ID; id != "" {
+				e.scheduler.count(id, evalResult.Error != nil)
+			}
 			if evalResult.Error != nil {
-				if errs == nil {
-					errs = make([]error, 0)
+				switch rs.errorPolicy(evalResult.Rule, evalResult.Phase, errorPolicy) {
+				case ErrorFallback:
+					evalResult.Value = rs.rules[evalResult.Rule].Fallback
+				case ErrorFailFast:
+					abort = true
+					fallthrough
+				default:
+					evalResult.Value = nil
+					result.Errors = append(result.Errors, rs.ruleError(evalResult.Rule, evalResult.Phase, evalResult.Error))
 				}
-				errs = append(errs, evalResult.Error)
-				continue
 			}
+
 			if evalResult.Value != nil {
-				r.FactsMutex.Lock()
-				r.Facts[evalResult.Key] = evalResult.Value
+				current, exist := r.Facts[evalResult.Key]
+				if !exist {
+					kept = true
+					r.held[evalResult.Key] = struct{}{}
+				} else {
+					owner, ok := r.owners[evalResult.Key]
+					if !ok {
+						owner = -1
+					}
+					kept, err = conflicts.resolve(rs, owner, current, evalResult)
+					if err != nil {
+						result.Errors = append(result.Errors, err)
+					}
+				}
+				if kept {
+					r.Facts[evalResult.Key] = evalResult.Value
+					r.owners[evalResult.Key] = evalResult.Rule
+				}
+			}
+
+			wave = r.settle(rs, needed, evalResult.Key, wave)
+
+			if config.trace != nil {
+				config.trace.record(rs, evalResult, waveNumber, kept)
+			}
+			if abort {
 				r.FactsMutex.Unlock()
+				return result
 			}
 		}
+		r.FactsMutex.Unlock()
+		if len(wave) == 0 {
+			wave = r.unblock(rs, needed, wave)
+		}
+		rs.sortWave(wave)
 	}
 
-	return r.Facts[endTarget], errs
+	result.Value, result.Found = r.Facts[target]
+	result.Targets = make(map[string]interface{}, len(targets))
+	for _, target := range targets {
+		if value, ok := r.Facts[target]; ok {
+			result.Targets[target] = value
+		}
+	}
+	if config.derived {
+		// a fact is inferred when a rule produced its final value, even over an initial fact
+		result.Inferred = make(map[string]interface{}, len(r.owners))
+		for key := range r.owners {
+			result.Inferred[key] = r.Facts[key]
+		}
+	}
+	if config.diagnose && !result.Found {
+		result.WhyNot = newDiagnoser(rs, r.Facts, outcomes).diagnose(target)
+	}
+	return result
 }
 
 // NewRuntime ...
@@ -306,18 +561,39 @@ func (e *Engine) NewRuntime(facts map[string]interface{}) *Runtime {
 
 // Evaluate will evaluate each job in runtime
 func (r *Runtime) Evaluate(job *Job, result chan<- *EvalResult) {
+	result <- r.evaluate(job)
+}
+
+func (r *Runtime) evaluate(job *Job) *EvalResult {
 	evalResult := &EvalResult{
-		Key: job.Output,
+		Rule: job.Rule,
+		Key:  job.Output,
+	}
+
+	// Skip the evaluation entirely when the run has already been aborted
+	if job.Context != nil {
+		if err := job.Context.Err(); err != nil {
+			evalResult.Error, evalResult.Phase = contextError(err), PhaseEvaluate
+			return evalResult
+		}
 	}
 
 	r.FactsMutex.RLock()
+	if job.Trace {
+		evalResult.Inputs = make(map[string]interface{}, len(job.Inputs))
+		for _, input := range job.Inputs {
+			evalResult.Inputs[input] = r.Facts[input]
+		}
+	}
+	start := time.Now()
 	res, err := job.ParsedExpression.Evaluate(r.Facts)
+	evalResult.Duration = time.Since(start)
 	r.FactsMutex.RUnlock()
 	if err != nil {
-		evalResult.Error = err
+		evalResult.Phase, evalResult.Error = evaluationError(err)
 	}
 	evalResult.Value = res
-	result <- evalResult
+	return evalResult
 }
 
 // Reset Current Runtime
@@ -325,5 +601,151 @@ func (r *Runtime) Reset() error {
 	for i := range r.UsedRule {
 		delete(r.UsedRule, i)
 	}
+	for key := range r.owners {
+		delete(r.owners, key)
+	}
+	for key := range r.unsettled {
+		delete(r.unsettled, key)
+	}
+	for key := range r.held {
+		delete(r.held, key)
+	}
+	r.Facts = nil
 	return nil
 }
+
+// start prepares the missing input counters of every rule in rs against the initial facts
+// and returns the rules ready for the first wave. When needed is set only those rules are ever scheduled.
+func (r *Runtime) start(rs *RuleSet, needed []bool) []int {
+	if cap(r.missing) < len(rs.inputs) {
+		r.missing = make([]int, len(rs.inputs))
+	}
+	r.missing = r.missing[:len(rs.inputs)]
+	for i, inputs := range rs.inputs {
+		r.missing[i] = len(inputs)
+	}
+
+	for output, producers := range rs.producers {
+		for _, i := range producers {
+			if needed == nil || needed[i] {
+				r.unsettled[output]++
+			}
+		}
+	}
+
+	var wave []int
+	for _, i := range rs.roots {
+		if needed == nil || needed[i] {
+			wave = append(wave, i)
+		}
+	}
+	for key := range r.Facts {
+		if r.unsettled[key] > 0 {
+			r.held[key] = struct{}{}
+			continue
+		}
+		wave = r.arrive(rs, needed, key, wave)
+	}
+	if len(wave) == 0 {
+		wave = r.unblock(rs, needed, wave)
+	}
+	rs.sortWave(wave)
+	return wave
+}
+
+// settle records that a producer of key fired. Once the last one did, the dependents of key are released
+// with its final value, so a later override never contradicts a rule which already fired.
+func (r *Runtime) settle(rs *RuleSet, needed []bool, key string, wave []int) []int {
+	r.unsettled[key]--
+	if r.unsettled[key] > 0 {
+		return wave
+	}
+	delete(r.unsettled, key)
+	if _, ok := r.held[key]; ok {
+		delete(r.held, key)
+		wave = r.arrive(rs, needed, key, wave)
+	}
+	return wave
+}
+
+// unblock releases every held fact once no rule is left to fire, their remaining producers can never fire,
+// e.g. when they depend on a held fact themselves
+func (r *Runtime) unblock(rs *RuleSet, needed []bool, wave []int) []int {
+	keys := make([]string, 0, len(r.held))
+	for key := range r.held {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		delete(r.held, key)
+		wave = r.arrive(rs, needed, key, wave)
+	}
+	return wave
+}
+
+// arrive marks key as a newly present fact and appends the rules waiting only for it into wave
+func (r *Runtime) arrive(rs *RuleSet, needed []bool, key string, wave []int) []int {
+	for _, i := range rs.dependents[key] {
+		r.missing[i]--
+		if r.missing[i] == 0 && (needed == nil || needed[i]) {
+			wave = append(wave, i)
+		}
+	}
+	return wave
+}
+
+// dispatch hands the jobs of a wave to the workers while collecting their results at the same time,
+// so a wave larger than jobCh and resultCh buffers can never wedge the workers against the scheduler.
+// At most window jobs of the run are queued or evaluating at once.
+func (r *Runtime) dispatch(ctx context.Context, jobs []*Job) ([]*EvalResult, error) {
+	results := make([]*EvalResult, 0, len(jobs))
+
+	for len(jobs) > 0 || r.pending > 0 {
+		// sending on a nil channel blocks forever, which disables the send case while the window is full
+		var jobCh chan *Job
+		var next *Job
+		if len(jobs) > 0 && r.pending < r.window {
+			jobCh = r.jobCh
+			next = jobs[0]
+		}
+
+		select {
+		case jobCh <- next:
+			jobs = jobs[1:]
+			r.pending++
+		case evalResult := <-r.resultCh:
+			r.pending--
+			results = append(results, evalResult)
+		case <-ctx.Done():
+			return results, contextError(ctx.Err())
+		}
+	}
+
+	return results, nil
+}
+
+// release gives the runtime back to the pool once every job still owned by the workers
+// has reported back, so an aborted run never leaks stale results into the next one. done is called afterward.
+func (r *Runtime) release(done func()) {
+	if r.pending == 0 {
+		r.DecrementReferenceCount()
+		done()
+		return
+	}
+
+	go func() {
+		for ; r.pending > 0; r.pending-- {
+			<-r.resultCh
+		}
+		r.DecrementReferenceCount()
+		done()
+	}()
+}
+
+// contextError maps context errors into fished errors, which still wrap them
+func contextError(err error) error {
+	if err == context.DeadlineExceeded {
+		return &abortError{reason: ErrTimeout, err: err}
+	}
+	return &abortError{reason: ErrCanceled, err: err}
+}
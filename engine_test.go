@@ -1,17 +1,17 @@
 package fished
 
 import (
-	"io/ioutil"
-	"os"
+	"context"
+	"errors"
+	"fmt"
 	"runtime"
+	"sync"
 	"testing"
+	"time"
 
-	jsoniter "github.com/json-iterator/go"
 	"github.com/stretchr/testify/assert"
 )
 
-var json = jsoniter.ConfigCompatibleWithStandardLibrary
-
 func TestRun(t *testing.T) {
 	tc := []struct {
 		Name           string
@@ -78,34 +78,42 @@ func TestRun(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name:   "tc6 priority override",
+			TCFile: "./test/tc6.json",
+			Facts: map[string]interface{}{
+				"account_partner": "hello",
+				"account_region":  "ID",
+			},
+			ExpectedResult: false,
+			IsError:        false,
+			Worker:         DefaultWorker,
+			Target:         DefaultTarget,
+		},
+		{
+			Name:   "tc6 priority override not applicable",
+			TCFile: "./test/tc6.json",
+			Facts: map[string]interface{}{
+				"account_partner": "hello",
+				"account_region":  "SG",
+			},
+			ExpectedResult: true,
+			IsError:        false,
+			Worker:         DefaultWorker,
+			Target:         DefaultTarget,
+		},
 	}
 
 	for _, test := range tc {
 		t.Run(test.Name, func(t *testing.T) {
-			// Open our jsonFile
-			jsonFile, err := os.Open(test.TCFile)
-			// if we os.Open returns an error then handle it
-			if !assert.Nil(t, err) {
-				return
-			}
-
-			// defer the closing of our jsonFile so that we can parse it later on
-			defer jsonFile.Close()
-
-			// read our opened xmlFile as a byte array.
-			byteValue, _ := ioutil.ReadAll(jsonFile)
-
-			var ruleMap struct {
-				Data []Rule `json:"data"`
-			}
-
-			err = json.Unmarshal(byteValue, &ruleMap)
+			ruleSetFile, err := LoadRuleSetFile(test.TCFile)
 			if !assert.Nil(t, err) {
 				return
 			}
 
 			e := NewWithCustomWorkerSize(test.Worker)
-			e.Set(test.Facts, ruleMap.Data, test.RuleFunction)
+			defer e.Close()
+			e.Set(test.Facts, ruleSetFile.Rules, test.RuleFunction)
 			res, errs := e.RunWithCustomTarget(test.Target)
 			if test.IsError {
 				assert.NotNil(t, errs)
@@ -186,30 +194,14 @@ func TestDoubleRun(t *testing.T) {
 
 	for _, test := range tc {
 		t.Run(test.Name, func(t *testing.T) {
-			// Open our jsonFile
-			jsonFile, err := os.Open(test.TCFile)
-			// if we os.Open returns an error then handle it
-			if !assert.Nil(t, err) {
-				return
-			}
-
-			// defer the closing of our jsonFile so that we can parse it later on
-			defer jsonFile.Close()
-
-			// read our opened xmlFile as a byte array.
-			byteValue, _ := ioutil.ReadAll(jsonFile)
-
-			var ruleMap struct {
-				Data []Rule `json:"data"`
-			}
-
-			err = json.Unmarshal(byteValue, &ruleMap)
+			ruleSetFile, err := LoadRuleSetFile(test.TCFile)
 			if !assert.Nil(t, err) {
 				return
 			}
 
 			e := NewWithCustomWorkerSize(test.Worker)
-			e.Set(test.Facts, ruleMap.Data, test.RuleFunction)
+			defer e.Close()
+			e.Set(test.Facts, ruleSetFile.Rules, test.RuleFunction)
 			res, errs := e.RunWithCustomTarget(test.Target)
 			if test.IsError {
 				assert.NotNil(t, errs)
@@ -227,10 +219,301 @@ func TestDoubleRun(t *testing.T) {
 	}
 }
 
+func TestRunContext(t *testing.T) {
+	release := make(chan struct{})
+	rules := []Rule{
+		{
+			Input:      []string{"example"},
+			Output:     "result_end",
+			Expression: "wait(example)",
+		},
+	}
+	ruleFunctions := map[string]RuleFunction{
+		"wait": func(args ...interface{}) (interface{}, error) {
+			<-release
+			return args[0], nil
+		},
+	}
+
+	e := NewWithCustomWorkerSize(1)
+	defer e.Close()
+	e.Set(map[string]interface{}{"example": true}, rules, ruleFunctions)
+
+	t.Run("deadline exceeded", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		res, errs := e.RunContext(ctx, DefaultTarget)
+		assert.Nil(t, res)
+		if assert.Len(t, errs, 1) {
+			assert.True(t, errors.Is(errs[0], ErrTimeout))
+			assert.True(t, errors.Is(errs[0], context.DeadlineExceeded))
+			assert.Equal(t, ErrTimeout.Error(), errs[0].Error())
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+		}()
+
+		res, errs := e.RunContext(ctx, DefaultTarget)
+		assert.Nil(t, res)
+		if assert.Len(t, errs, 1) {
+			assert.True(t, errors.Is(errs[0], ErrCanceled))
+			assert.True(t, errors.Is(errs[0], context.Canceled))
+		}
+	})
+
+	t.Run("reuse after abort", func(t *testing.T) {
+		close(release)
+
+		for i := 0; i < 10; i++ {
+			res, errs := e.RunContext(context.Background(), DefaultTarget)
+			assert.Nil(t, errs)
+			assert.Equal(t, true, res)
+		}
+	})
+}
+
+// generateWideRules builds width independent rules reading seed, each followed by a rule reading its output,
+// so every wave is far larger than DefaultRuleLength
+func generateWideRules(width int) []Rule {
+	rules := make([]Rule, 0, width*2+1)
+	for i := 0; i < width; i++ {
+		rules = append(rules, Rule{
+			Input:      []string{"seed"},
+			Output:     fmt.Sprintf("first_%d", i),
+			Expression: fmt.Sprintf("seed + %d", i),
+		})
+		rules = append(rules, Rule{
+			Input:      []string{fmt.Sprintf("first_%d", i)},
+			Output:     fmt.Sprintf("second_%d", i),
+			Expression: fmt.Sprintf("first_%d * 2", i),
+		})
+	}
+	last := fmt.Sprintf("second_%d", width-1)
+	rules = append(rules, Rule{
+		Input:      []string{"second_0", last},
+		Output:     DefaultTarget,
+		Expression: fmt.Sprintf("second_0 + %s", last),
+	})
+	return rules
+}
+
+func TestRunLargeWave(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Width  int
+		Worker int
+	}{
+		{Name: "just above buffer", Width: DefaultRuleLength*2 + 1, Worker: 1},
+		{Name: "10k rules 1 worker", Width: 10000, Worker: 1},
+		{Name: "20k rules default worker", Width: 20000, Worker: DefaultWorker},
+	}
+
+	for _, test := range tc {
+		t.Run(test.Name, func(t *testing.T) {
+			e := NewWithCustomWorkerSize(test.Worker)
+			defer e.Close()
+			e.Set(map[string]interface{}{"seed": 1}, generateWideRules(test.Width), nil)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			res, errs := e.RunContext(ctx, DefaultTarget)
+			assert.Nil(t, errs)
+			assert.Equal(t, float64(2+2*test.Width), res)
+		})
+	}
+}
+
+// generateChainRules builds a chain of depth rules where every rule depends on the previous one
+func generateChainRules(depth int) []Rule {
+	rules := make([]Rule, 0, depth)
+	previous := "seed"
+	for i := 0; i < depth; i++ {
+		output := fmt.Sprintf("chain_%d", i)
+		if i == depth-1 {
+			output = DefaultTarget
+		}
+		// declared in reverse so every wave needs the rule declared right before the previous one
+		rules = append([]Rule{{
+			Input:      []string{previous},
+			Output:     output,
+			Expression: fmt.Sprintf("%s + 1", previous),
+		}}, rules...)
+		previous = output
+	}
+	return rules
+}
+
+func TestRunDeepChain(t *testing.T) {
+	e := New()
+	defer e.Close()
+	e.Set(map[string]interface{}{"seed": 0}, generateChainRules(2000), nil)
+
+	res, errs := e.RunDefault()
+	assert.Nil(t, errs)
+	assert.Equal(t, float64(2000), res)
+
+	// a rule never gets scheduled while one of its inputs is missing
+	e.SetFacts(map[string]interface{}{"other": 0})
+	res, errs = e.RunDefault()
+	assert.Nil(t, errs)
+	assert.Nil(t, res)
+}
+
+func TestRunGoalDirected(t *testing.T) {
+	var mu sync.Mutex
+	var evaluated []string
+	rules := []Rule{
+		{Input: []string{"account_partner"}, Output: "account_type", Expression: "trace('account_type', account_partner == 'hello' ? 'free' : 'paid')"},
+		{Input: []string{"account_type"}, Output: "account_type_eligible", Expression: "trace('account_type_eligible', account_type == 'free')"},
+		{Input: []string{"account_region"}, Output: "account_region_eligible", Expression: "trace('account_region_eligible', account_region == 'ID')"},
+		{Input: []string{"account_type_eligible", "account_region_eligible"}, Output: "result_end", Expression: "trace('result_end', account_type_eligible && account_region_eligible)"},
+	}
+	ruleFunctions := map[string]RuleFunction{
+		"trace": func(args ...interface{}) (interface{}, error) {
+			mu.Lock()
+			evaluated = append(evaluated, args[0].(string))
+			mu.Unlock()
+			return args[1], nil
+		},
+	}
+
+	e := New()
+	defer e.Close()
+	e.Set(map[string]interface{}{
+		"account_partner": "hello",
+		"account_region":  "ID",
+	}, rules, ruleFunctions)
+
+	res, errs := e.RunContext(context.Background(), "account_type_eligible", GoalDirected())
+	assert.Nil(t, errs)
+	assert.Equal(t, true, res)
+	assert.ElementsMatch(t, []string{"account_type", "account_type_eligible"}, evaluated)
+
+	evaluated = nil
+	res, errs = e.RunContext(context.Background(), DefaultTarget, GoalDirected())
+	assert.Nil(t, errs)
+	assert.Equal(t, true, res)
+	assert.Len(t, evaluated, 4)
+}
+
+func TestEngineClose(t *testing.T) {
+	t.Run("no leaked workers", func(t *testing.T) {
+		baseline := runtime.NumGoroutine()
+
+		for i := 0; i < 2000; i++ {
+			e := NewWithCustomWorkerSize(4)
+			e.Set(map[string]interface{}{"seed": i}, generateChainRules(3), nil)
+			res, errs := e.RunDefault()
+			assert.Nil(t, errs)
+			assert.Equal(t, float64(i+3), res)
+			assert.Nil(t, e.Close())
+		}
+
+		// exiting goroutines may take a moment to be accounted for
+		deadline := time.Now().Add(5 * time.Second)
+		for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.True(t, runtime.NumGoroutine() <= baseline, "%d goroutines left, expected %d", runtime.NumGoroutine(), baseline)
+	})
+
+	t.Run("waits for in-flight runs", func(t *testing.T) {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		e := New()
+		e.Set(map[string]interface{}{"example": true}, []Rule{
+			{Input: []string{"example"}, Output: DefaultTarget, Expression: "wait(example)"},
+		}, map[string]RuleFunction{
+			"wait": func(args ...interface{}) (interface{}, error) {
+				close(started)
+				<-release
+				return args[0], nil
+			},
+		})
+
+		done := make(chan interface{})
+		go func() {
+			res, _ := e.RunDefault()
+			done <- res
+		}()
+		<-started
+
+		closed := make(chan struct{})
+		go func() {
+			e.Close()
+			close(closed)
+		}()
+
+		select {
+		case <-closed:
+			t.Fatal("Close returned before the in-flight run finished")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		close(release)
+		assert.Equal(t, true, <-done)
+		<-closed
+
+		res, errs := e.RunDefault()
+		assert.Nil(t, res)
+		assert.Equal(t, MultiError{ErrClosed}, errs)
+		assert.Nil(t, e.Close())
+	})
+}
+
+func TestEvaluate(t *testing.T) {
+	rules := []Rule{
+		{Input: []string{"account_partner"}, Output: "account_type", Expression: "account_partner == 'hello' ? 'free' : 'paid'"},
+		{Input: []string{"account_type", "account_region"}, Output: DefaultTarget, Expression: "account_type == 'free' && account_region == 'ID'"},
+	}
+
+	e := New()
+	defer e.Close()
+	e.Set(map[string]interface{}{"account_region": "ID"}, rules, nil)
+
+	tc := []struct {
+		Facts          map[string]interface{}
+		ExpectedResult interface{}
+	}{
+		{Facts: map[string]interface{}{"account_partner": "hello"}, ExpectedResult: true},
+		{Facts: map[string]interface{}{"account_partner": "world"}, ExpectedResult: false},
+		{Facts: map[string]interface{}{"account_partner": "hello", "account_region": "SG"}, ExpectedResult: false},
+		{Facts: nil, ExpectedResult: nil},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		test := tc[i%len(tc)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, errs := e.Evaluate(test.Facts, DefaultTarget)
+			assert.Nil(t, errs)
+			assert.Equal(t, test.ExpectedResult, res)
+		}()
+	}
+	wg.Wait()
+
+	// engine defaults are left untouched
+	result := e.RunDetailed(context.Background(), DefaultTarget, WithFacts(map[string]interface{}{"account_partner": "hello"}))
+	assert.Equal(t, true, result.Value)
+	res, _ := e.RunDefault()
+	assert.Nil(t, res)
+}
+
 func BenchmarkRun(b *testing.B) {
+	large := map[string]interface{}{"seed": 0}
 	tc := []struct {
 		Name           string
 		TCFile         string
+		Rules          []Rule
 		Facts          map[string]interface{}
 		RuleFunction   map[string]RuleFunction
 		Target         string
@@ -306,28 +589,27 @@ func BenchmarkRun(b *testing.B) {
 				},
 			},
 		},
+		{Name: "deep chain 100", Rules: generateChainRules(100), Facts: large, Worker: DefaultWorker, Target: DefaultTarget},
+		{Name: "deep chain 2000", Rules: generateChainRules(2000), Facts: large, Worker: DefaultWorker, Target: DefaultTarget},
+		{Name: "wide 1000", Rules: generateWideRules(1000), Facts: large, Worker: DefaultWorker, Target: DefaultTarget},
+		{Name: "wide 10000", Rules: generateWideRules(10000), Facts: large, Worker: DefaultWorker, Target: DefaultTarget},
 	}
 
 	for _, test := range tc {
 		b.Run(test.Name, func(b *testing.B) {
-			// Open our jsonFile
-			jsonFile, _ := os.Open(test.TCFile)
-			// if we os.Open returns an error then handle it
-
-			// defer the closing of our jsonFile so that we can parse it later on
-			defer jsonFile.Close()
-
-			// read our opened xmlFile as a byte array.
-			byteValue, _ := ioutil.ReadAll(jsonFile)
-
-			var ruleMap struct {
-				Data []Rule `json:"data"`
+			rules := test.Rules
+			if test.TCFile != "" {
+				ruleSetFile, err := LoadRuleSetFile(test.TCFile)
+				if err != nil {
+					b.Fatal(err)
+				}
+				rules = ruleSetFile.Rules
 			}
 
-			json.Unmarshal(byteValue, &ruleMap)
-
 			e := NewWithCustomWorkerSize(test.Worker)
-			e.Set(test.Facts, ruleMap.Data, test.RuleFunction)
+			defer e.Close()
+			e.Set(test.Facts, rules, test.RuleFunction)
+			b.ResetTimer()
 			for i := 0; i < b.N; i++ {
 				e.RunWithCustomTarget(test.Target)
 			}
@@ -418,26 +700,16 @@ func BenchmarkRunFullRemakeEngine(b *testing.B) {
 
 	for _, test := range tc {
 		b.Run(test.Name, func(b *testing.B) {
-			// Open our jsonFile
-			jsonFile, _ := os.Open(test.TCFile)
-			// if we os.Open returns an error then handle it
-
-			// defer the closing of our jsonFile so that we can parse it later on
-			defer jsonFile.Close()
-
-			// read our opened xmlFile as a byte array.
-			byteValue, _ := ioutil.ReadAll(jsonFile)
-
-			var ruleMap struct {
-				Data []Rule `json:"data"`
+			ruleSetFile, err := LoadRuleSetFile(test.TCFile)
+			if err != nil {
+				b.Fatal(err)
 			}
 
-			json.Unmarshal(byteValue, &ruleMap)
-
 			for i := 0; i < b.N; i++ {
 				e := NewWithCustomWorkerSize(test.Worker)
-				e.Set(test.Facts, ruleMap.Data, test.RuleFunction)
+				e.Set(test.Facts, ruleSetFile.Rules, test.RuleFunction)
 				e.RunWithCustomTarget(test.Target)
+				e.Close()
 			}
 		})
 	}
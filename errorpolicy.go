@@ -0,0 +1,84 @@
+package fished
+
+import (
+	"fmt"
+)
+
+const (
+	// ErrorDefault leaves the decision to the engine policy when set on a rule. As engine policy,
+	// a rule which can not be parsed aborts the run like ErrorFailFast, while evaluation errors are handled like ErrorContinue.
+	ErrorDefault ErrorPolicy = iota
+	// ErrorContinue reports the error and goes on without the output of the failing rule
+	ErrorContinue
+	// ErrorFailFast aborts the run on the first error, in firing order. Rules which can not be parsed abort it before any rule fires.
+	ErrorFailFast
+	// ErrorFallback sets the output of the failing rule to its Fallback value, so rules depending on it still fire.
+	// The error is not reported but stays visible in the trace. Rules without a Fallback behave like ErrorContinue.
+	ErrorFallback
+)
+
+// ErrorPolicy decides what a run does when a rule fails, for every rule of an engine or for a single rule
+type ErrorPolicy int
+
+var errorPolicyNames = map[ErrorPolicy]string{
+	ErrorDefault:  "",
+	ErrorContinue: "continue",
+	ErrorFailFast: "fail_fast",
+	ErrorFallback: "fallback",
+}
+
+// SetErrorPolicy will set the error policy used for every rule without its own OnError policy
+func (e *Engine) SetErrorPolicy(policy ErrorPolicy) error {
+	e.update(func(s *snapshot) {
+		s.errorPolicy = policy
+	})
+	return nil
+}
+
+// errorPolicy resolves the policy applied when rule i fails in phase, given the engine policy
+func (rs *RuleSet) errorPolicy(i int, phase Phase, engine ErrorPolicy) ErrorPolicy {
+	policy := rs.rules[i].OnError
+	if policy == ErrorDefault {
+		policy = engine
+	}
+
+	switch {
+	case policy == ErrorDefault && phase == PhaseParse:
+		return ErrorFailFast
+	case policy == ErrorDefault:
+		return ErrorContinue
+	case policy == ErrorFallback && rs.rules[i].Fallback == nil:
+		return ErrorContinue
+	}
+	return policy
+}
+
+func (p ErrorPolicy) String() string {
+	if name, ok := errorPolicyNames[p]; ok && name != "" {
+		return name
+	}
+	if p == ErrorDefault {
+		return "default"
+	}
+	return fmt.Sprintf("ErrorPolicy(%d)", int(p))
+}
+
+// MarshalText encodes the policy by name, so rules read well in JSON
+func (p ErrorPolicy) MarshalText() ([]byte, error) {
+	name, ok := errorPolicyNames[p]
+	if !ok {
+		return nil, fmt.Errorf("fished: unknown error policy %d", int(p))
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText decodes a policy encoded by MarshalText
+func (p *ErrorPolicy) UnmarshalText(text []byte) error {
+	for policy, name := range errorPolicyNames {
+		if name == string(text) {
+			*p = policy
+			return nil
+		}
+	}
+	return fmt.Errorf("fished: unknown error policy %q", text)
+}
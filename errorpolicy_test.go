@@ -0,0 +1,147 @@
+package fished
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorPolicy(t *testing.T) {
+	geoErr := errors.New("geo lookup failed")
+	functions := map[string]RuleFunction{
+		"geo": func(args ...interface{}) (interface{}, error) {
+			return nil, geoErr
+		},
+	}
+	rules := []Rule{
+		{Input: []string{"ip"}, Output: "region", Expression: "geo(ip)", Fallback: "unknown region"},
+		{Input: []string{"region"}, Output: "region_known", Expression: "region != 'unknown region'"},
+		{Input: []string{"account_partner"}, Output: "account_type", Expression: "account_partner == 'hello' ? 'free' : 'paid'"},
+		{Input: []string{"region_known", "account_type"}, Output: DefaultTarget, Expression: "region_known && account_type == 'free'"},
+	}
+	facts := map[string]interface{}{
+		"ip":              "10.0.0.1",
+		"account_partner": "hello",
+	}
+
+	tc := []struct {
+		Name           string
+		Policy         ErrorPolicy
+		Rules          []Rule
+		ExpectedResult interface{}
+		ExpectedErrors int
+		ExpectedPhase  Phase
+		Fired          int
+	}{
+		{Name: "default continues", Policy: ErrorDefault, Rules: rules, ExpectedErrors: 1, ExpectedPhase: PhaseFunction, Fired: 2},
+		{Name: "continue", Policy: ErrorContinue, Rules: rules, ExpectedErrors: 1, ExpectedPhase: PhaseFunction, Fired: 2},
+		{Name: "fail fast", Policy: ErrorFailFast, Rules: rules, ExpectedErrors: 1, ExpectedPhase: PhaseFunction, Fired: 1},
+		{Name: "fallback", Policy: ErrorFallback, Rules: rules, ExpectedResult: false, Fired: 4},
+		{
+			Name:   "rule policy overrides engine policy",
+			Policy: ErrorFailFast,
+			Rules: append([]Rule{
+				{Input: []string{"ip"}, Output: "region", Expression: "geo(ip)", Fallback: "unknown region", OnError: ErrorFallback},
+			}, rules[1:]...),
+			ExpectedResult: false,
+			Fired:          4,
+		},
+		{
+			Name:   "fallback without value continues",
+			Policy: ErrorFallback,
+			Rules: append([]Rule{
+				{Input: []string{"ip"}, Output: "region", Expression: "geo(ip)"},
+			}, rules[1:]...),
+			ExpectedErrors: 1,
+			ExpectedPhase:  PhaseFunction,
+			Fired:          2,
+		},
+	}
+
+	for _, test := range tc {
+		t.Run(test.Name, func(t *testing.T) {
+			e := New()
+			defer e.Close()
+			e.Set(facts, test.Rules, functions)
+			e.SetErrorPolicy(test.Policy)
+
+			var trace Trace
+			res, errs := e.RunContext(context.Background(), DefaultTarget, WithTrace(&trace))
+			assert.Equal(t, test.ExpectedResult, res)
+			assert.Len(t, trace.Steps, test.Fired)
+			if assert.Len(t, errs, test.ExpectedErrors) && test.ExpectedErrors > 0 {
+				assert.True(t, errors.Is(errs[0], geoErr))
+				assert.Equal(t, test.ExpectedPhase, errs[0].(*RuleError).Phase)
+			}
+			// the failure stays in the trace even when replaced by a fallback
+			assert.Equal(t, geoErr, trace.Steps[0].Error)
+		})
+	}
+}
+
+func TestErrorPolicyParse(t *testing.T) {
+	rules := []Rule{
+		{Input: []string{"account_partner"}, Output: "account_type", Expression: "account_partner == 'hello : 'free' ? 'paid'", Fallback: "paid"},
+		{Input: []string{"account_region"}, Output: "account_region_eligible", Expression: "account_region == 'ID'"},
+		{Input: []string{"account_type", "account_region_eligible"}, Output: DefaultTarget, Expression: "account_type == 'paid' && account_region_eligible"},
+		{Input: []string{"account_region_eligible"}, Output: "isEligible", Expression: "account_region_eligible"},
+	}
+	facts := map[string]interface{}{
+		"account_partner": "hello",
+		"account_region":  "ID",
+	}
+
+	tc := []struct {
+		Name           string
+		Policy         ErrorPolicy
+		Target         string
+		GoalDirected   bool
+		ExpectedResult interface{}
+		ExpectedErrors int
+	}{
+		{Name: "default aborts the run", Policy: ErrorDefault, Target: "isEligible", ExpectedErrors: 1},
+		{Name: "continue runs the other rules", Policy: ErrorContinue, Target: "isEligible", ExpectedResult: true, ExpectedErrors: 1},
+		{Name: "continue misses the output", Policy: ErrorContinue, Target: DefaultTarget, ExpectedErrors: 1},
+		{Name: "fallback", Policy: ErrorFallback, Target: DefaultTarget, ExpectedResult: true},
+		{Name: "goal directed skips rules the target does not need", Policy: ErrorDefault, Target: "isEligible", GoalDirected: true, ExpectedResult: true},
+	}
+
+	for _, test := range tc {
+		t.Run(test.Name, func(t *testing.T) {
+			e := New()
+			defer e.Close()
+			e.Set(facts, rules, nil)
+			e.SetErrorPolicy(test.Policy)
+
+			var opts []RunOption
+			if test.GoalDirected {
+				opts = append(opts, GoalDirected())
+			}
+			res, errs := e.RunContext(context.Background(), test.Target, opts...)
+			assert.Equal(t, test.ExpectedResult, res)
+			if assert.Len(t, errs, test.ExpectedErrors) && test.ExpectedErrors > 0 {
+				assert.Equal(t, PhaseParse, errs[0].(*RuleError).Phase)
+				assert.Equal(t, 0, errs[0].(*RuleError).Rule)
+			}
+		})
+	}
+}
+
+func TestErrorPolicyJSON(t *testing.T) {
+	var rule Rule
+	err := json.Unmarshal([]byte(`{"input": ["ip"], "output": "region", "expression": "geo(ip)", "on_error": "fallback", "fallback": "unknown region"}`), &rule)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, ErrorFallback, rule.OnError)
+	assert.Equal(t, "unknown region", rule.Fallback)
+
+	encoded, err := json.Marshal(Rule{Output: "region", OnError: ErrorFailFast})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"input": null, "output": "region", "expression": "", "on_error": "fail_fast"}`, string(encoded))
+
+	assert.NotNil(t, json.Unmarshal([]byte(`{"on_error": "retry"}`), &rule))
+}
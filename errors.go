@@ -0,0 +1,159 @@
+package fished
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/knetic/govaluate"
+)
+
+// Phase in which a rule failed
+const (
+	PhaseParse Phase = iota
+	PhaseEvaluate
+	PhaseFunction
+)
+
+var (
+	// ErrDuplicateRuleID is wrapped by the RuleError of a rule reusing the ID of a previous rule
+	ErrDuplicateRuleID = errors.New("fished: duplicate rule id")
+
+	// ErrReservedFunction is returned when compiling rule functions whose name starts with "fished_",
+	// which is kept for the builtin functions
+	ErrReservedFunction = errors.New("fished: reserved rule function name")
+)
+
+type (
+	// Phase tells whether a rule failed while its expression was parsed, evaluated, or in a rule function it called
+	Phase int
+
+	// RuleError is returned when a rule fails, it wraps the cause so errors.Is and errors.As see through it
+	RuleError struct {
+		Rule       int
+		RuleID     string
+		Output     string
+		Expression string
+		Phase      Phase
+		Err        error
+	}
+
+	// MultiError holds every error of a compilation or a run, it is nil when there is none
+	MultiError []error
+
+	// functionError marks errors returned by rule functions, so they are not mistaken for evaluation errors
+	functionError struct {
+		err error
+	}
+
+	// abortError is ErrTimeout or ErrCanceled, wrapping the error of the context which aborted the run
+	abortError struct {
+		reason error
+		err    error
+	}
+)
+
+// ruleError wraps err into a RuleError describing rule i
+func (rs *RuleSet) ruleError(i int, phase Phase, err error) *RuleError {
+	return newRuleError(rs.rules, i, phase, err)
+}
+
+func newRuleError(rules []Rule, i int, phase Phase, err error) *RuleError {
+	return &RuleError{
+		Rule:       i,
+		RuleID:     rules[i].ID,
+		Output:     rules[i].Output,
+		Expression: rules[i].Expression,
+		Phase:      phase,
+		Err:        err,
+	}
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("%s: %v error: %v", ruleName(e.Rule, e.RuleID), e.Phase, e.Err)
+}
+
+// ruleName names rule i in messages, along with its ID when it has one
+func ruleName(i int, id string) string {
+	if id == "" {
+		return fmt.Sprintf("rule %d", i)
+	}
+	return fmt.Sprintf("rule %d %q", i, id)
+}
+
+// Unwrap returns the cause of the rule failure
+func (e *RuleError) Unwrap() error {
+	return e.Err
+}
+
+func (e MultiError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns every error, so errors.Is and errors.As match any of them
+func (e MultiError) Unwrap() []error {
+	return e
+}
+
+// Err returns e as an error, or a nil error when e is empty
+func (e MultiError) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseParse:
+		return "parse"
+	case PhaseEvaluate:
+		return "evaluate"
+	case PhaseFunction:
+		return "function"
+	default:
+		return fmt.Sprintf("Phase(%d)", int(p))
+	}
+}
+
+func (e *functionError) Error() string {
+	return e.err.Error()
+}
+
+// expressionFunction adapts a rule function to govaluate, marking the errors it returns
+func expressionFunction(fn RuleFunction) govaluate.ExpressionFunction {
+	return func(args ...interface{}) (interface{}, error) {
+		res, err := fn(args...)
+		if err != nil {
+			return nil, &functionError{err: err}
+		}
+		return res, nil
+	}
+}
+
+// evaluationError splits an expression error into the phase it happened in and its cause
+func evaluationError(err error) (Phase, error) {
+	var fnErr *functionError
+	if errors.As(err, &fnErr) {
+		return PhaseFunction, fnErr.err
+	}
+	return PhaseEvaluate, err
+}
+
+func (e *abortError) Error() string {
+	return e.reason.Error()
+}
+
+// Is matches the reason of the abort, ErrTimeout or ErrCanceled
+func (e *abortError) Is(target error) bool {
+	return target == e.reason
+}
+
+// Unwrap returns the error of the context
+func (e *abortError) Unwrap() error {
+	return e.err
+}
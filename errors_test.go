@@ -0,0 +1,57 @@
+package fished
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleError(t *testing.T) {
+	geoErr := errors.New("geo lookup failed")
+	rules := []Rule{
+		{Input: []string{"account_region"}, Output: "account_region_eligible", Expression: "geo(account_region)"},
+		{Input: []string{"account_age"}, Output: "account_age_eligible", Expression: "account_age > 18"},
+		{Input: []string{"account_age_eligible"}, Output: DefaultTarget, Expression: "account_age_eligible"},
+	}
+
+	e := New()
+	defer e.Close()
+	e.Set(map[string]interface{}{
+		"account_region": "ID",
+		"account_age":    "eighteen",
+	}, rules, map[string]RuleFunction{
+		"geo": func(args ...interface{}) (interface{}, error) {
+			return nil, geoErr
+		},
+	})
+
+	res, errs := e.RunDefault()
+	assert.Nil(t, res)
+	if !assert.Len(t, errs, 2) {
+		return
+	}
+
+	err := errs.Err()
+	assert.True(t, errors.Is(err, geoErr))
+	var ruleErr *RuleError
+	if assert.True(t, errors.As(err, &ruleErr)) {
+		assert.Equal(t, 0, ruleErr.Rule)
+		assert.Equal(t, "account_region_eligible", ruleErr.Output)
+		assert.Equal(t, "geo(account_region)", ruleErr.Expression)
+		assert.Equal(t, PhaseFunction, ruleErr.Phase)
+		assert.Equal(t, "rule 0: function error: geo lookup failed", ruleErr.Error())
+	}
+
+	ruleErr = errs[1].(*RuleError)
+	assert.Equal(t, 1, ruleErr.Rule)
+	assert.Equal(t, PhaseEvaluate, ruleErr.Phase)
+	assert.False(t, errors.Is(ruleErr, geoErr))
+
+	t.Run("no error", func(t *testing.T) {
+		res, errs := e.EvaluateContext(context.Background(), map[string]interface{}{"account_age": 20}, DefaultTarget, GoalDirected())
+		assert.Equal(t, true, res)
+		assert.Nil(t, errs.Err())
+	})
+}
@@ -0,0 +1,36 @@
+package fished
+
+import (
+	"io"
+)
+
+// File returns the rule set as a rule set file, ready to be written, with the comment heading the file it was
+// compiled from. Rules keep their declaration order, which decides between rules of the same priority.
+func (rs *RuleSet) File() *RuleSetFile {
+	return &RuleSetFile{
+		FormatVersion: FormatVersion,
+		Name:          rs.name,
+		Version:       rs.version,
+		Target:        rs.target,
+		Comment:       rs.comment,
+		Rules:         rs.Rules(),
+	}
+}
+
+// Export writes the rule set in format. The output only depends on the rules, so exporting the same rules
+// twice gives the same bytes and loading the output gives the same rule set back.
+func (rs *RuleSet) Export(w io.Writer, format Format) error {
+	return rs.File().Write(w, format)
+}
+
+// Export writes the rules of the engine in format like RuleSet.Export, including rules which can not be parsed
+func (e *Engine) Export(w io.Writer, format Format) error {
+	s := e.load()
+	f := &RuleSetFile{FormatVersion: FormatVersion}
+	if s.ruleSet != nil {
+		f = s.ruleSet.File()
+	}
+	f.Rules = make([]Rule, len(s.rules))
+	copy(f.Rules, s.rules)
+	return f.Write(w, format)
+}
@@ -0,0 +1,138 @@
+package fished
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport(t *testing.T) {
+	f, err := LoadRuleSetFile("./test/tc8.yaml")
+	if !assert.Nil(t, err) {
+		return
+	}
+	rs, err := f.Compile(nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	for _, format := range []Format{FormatJSON, FormatYAML} {
+		t.Run("golden "+string(format), func(t *testing.T) {
+			golden, err := ioutil.ReadFile("./test/tc8.golden." + string(format))
+			if !assert.Nil(t, err) {
+				return
+			}
+			var buf bytes.Buffer
+			assert.Nil(t, rs.Export(&buf, format))
+			assert.Equal(t, string(golden), buf.String())
+
+			exported, err := LoadRuleSetFormat(&buf, format)
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, f, exported)
+		})
+	}
+
+	paths, _ := filepath.Glob("./test/tc[1-8].*")
+	for _, path := range paths {
+		t.Run("round trip "+filepath.Base(path), func(t *testing.T) {
+			f, err := LoadRuleSetFile(path)
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			// the engine exports its rules even when they can not be parsed
+			e := New()
+			defer e.Close()
+			e.SetRules(f.Rules)
+
+			for _, format := range []Format{FormatJSON, FormatYAML} {
+				var buf bytes.Buffer
+				if !assert.Nil(t, e.Export(&buf, format)) {
+					return
+				}
+				first := buf.String()
+
+				exported, err := LoadRuleSetFormat(&buf, format)
+				if !assert.Nil(t, err) {
+					return
+				}
+				assert.Equal(t, f.Rules, exported.Rules)
+
+				// exporting what was exported changes nothing
+				e.SetRules(exported.Rules)
+				buf.Reset()
+				assert.Nil(t, e.Export(&buf, format))
+				assert.Equal(t, first, buf.String())
+			}
+		})
+	}
+
+	tc := []struct {
+		Path    string
+		Targets []string
+		Facts   map[string]interface{}
+	}{
+		{Path: "./test/tc9.csv", Targets: []string{"isEligible", "discount"}, Facts: map[string]interface{}{"account_partner": "hello", "account_region": "MY", "flight_type": "domestic"}},
+		{Path: "./test/tc9.json", Targets: []string{"isEligible", "discount"}, Facts: map[string]interface{}{"account_partner": "hello", "account_region": "SG", "flight_type": "domestic"}},
+		{Path: "./test/tc10.dmn", Targets: []string{"isEligible"}, Facts: map[string]interface{}{"Account Partner": "hi", "account_region": "SG", "account_age": 65}},
+	}
+
+	// rule sets compiled from decision tables and DMN models still run once exported and loaded back
+	for _, test := range tc {
+		t.Run("round trip "+filepath.Base(test.Path), func(t *testing.T) {
+			var rs *RuleSet
+			var err error
+			if filepath.Ext(test.Path) == ".dmn" {
+				var f *RuleSetFile
+				if f, err = LoadDMNFile(test.Path); err == nil {
+					rs, err = f.Compile(nil)
+				}
+			} else {
+				var table *DecisionTable
+				if table, err = LoadDecisionTableFile(test.Path); err == nil {
+					rs, err = table.Compile(nil)
+				}
+			}
+			if !assert.Nil(t, err) {
+				return
+			}
+			e := New()
+			defer e.Close()
+			e.SetRuleSet(rs)
+			expected := e.RunDetailed(context.Background(), test.Targets[0], WithFacts(test.Facts), WithTargets(test.Targets...))
+			assert.Nil(t, expected.Errors)
+			assert.True(t, expected.Found)
+			assert.Len(t, expected.Targets, len(test.Targets))
+
+			for _, format := range []Format{FormatJSON, FormatYAML} {
+				var buf bytes.Buffer
+				if !assert.Nil(t, rs.Export(&buf, format)) {
+					return
+				}
+				f, err := LoadRuleSetFormat(&buf, format)
+				if !assert.Nil(t, err) {
+					return
+				}
+				assert.Equal(t, rs.File(), f)
+				exported, err := f.Compile(nil)
+				if !assert.Nil(t, err) {
+					return
+				}
+
+				loaded := New()
+				defer loaded.Close()
+				loaded.SetRuleSet(exported)
+				res := loaded.RunDetailed(context.Background(), test.Targets[0], WithFacts(test.Facts), WithTargets(test.Targets...))
+				assert.Nil(t, res.Errors)
+				assert.Equal(t, expected.Value, res.Value)
+				assert.Equal(t, expected.Targets, res.Targets)
+			}
+		})
+	}
+}
@@ -0,0 +1,468 @@
+package fished
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/knetic/govaluate"
+)
+
+const (
+	// SeverityWarning marks issues which do not keep a rule set from running
+	SeverityWarning Severity = iota
+	// SeverityError marks issues which make a rule set misbehave at run time
+	SeverityError
+)
+
+const (
+	// IssueParse is reported for expressions that can not be parsed
+	IssueParse IssueKind = "parse"
+	// IssueUnknownFunction is reported for functions that are not registered rule functions
+	IssueUnknownFunction IssueKind = "unknown-function"
+	// IssueUndeclaredVariable is reported for variables used in an expression but not listed in its input
+	IssueUndeclaredVariable IssueKind = "undeclared-variable"
+	// IssueUnproducedInput is reported for inputs neither produced by a rule nor part of the initial facts
+	IssueUnproducedInput IssueKind = "unproduced-input"
+	// IssueDuplicateOutput is reported for outputs produced by more than one rule
+	IssueDuplicateOutput IssueKind = "duplicate-output"
+	// IssueUnreachableRule is reported for rules that can never fire
+	IssueUnreachableRule IssueKind = "unreachable-rule"
+	// IssueCycle is reported for rules depending on each other through their input and output
+	IssueCycle IssueKind = "cycle"
+	// IssueUnreachableTarget is reported for targets that can never be produced
+	IssueUnreachableTarget IssueKind = "unreachable-target"
+	// IssueDuplicateID is reported for rule IDs used by more than one rule
+	IssueDuplicateID IssueKind = "duplicate-id"
+)
+
+type (
+	// Severity of an Issue
+	Severity int
+
+	// IssueKind identifies the kind of problem an Issue reports
+	IssueKind string
+
+	// Issue is a single problem found by Lint. Rules lists the index of every rule involved,
+	// RuleIDs their ID when one of them has an ID.
+	Issue struct {
+		Kind     IssueKind
+		Severity Severity
+		Rules    []int
+		RuleIDs  []string
+		Fact     string
+		Message  string
+	}
+
+	// LintOptions describes the environment a rule set is going to run in
+	LintOptions struct {
+		// Facts is the initial fact schema. When nil, every input no rule produces is assumed to be an initial fact.
+		Facts []string
+		// Targets are the facts the rule set has to produce, DefaultTarget when empty
+		Targets []string
+	}
+
+	// ValidationError holds every error Issue found by Validate
+	ValidationError []Issue
+)
+
+var (
+	functionCallPattern = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*)\s*\(`)
+	literalPattern      = regexp.MustCompile(`'[^']*'|"[^"]*"|\[[^\]]*\]`)
+)
+
+// Validate lints the rules and returns a ValidationError when any issue is an error
+func Validate(rules []Rule, ruleFunctions map[string]RuleFunction, opts LintOptions) error {
+	var validationErr ValidationError
+	for _, issue := range Lint(rules, ruleFunctions, opts) {
+		if issue.Severity == SeverityError {
+			validationErr = append(validationErr, issue)
+		}
+	}
+
+	if validationErr != nil {
+		return validationErr
+	}
+	return nil
+}
+
+// Lint statically checks rules against the registered rule functions and the given options
+// and reports every issue found, ordered by kind of check then by rule
+func Lint(rules []Rule, ruleFunctions map[string]RuleFunction, opts LintOptions) []Issue {
+	var issues []Issue
+
+	producers := make(map[string][]int)
+	for i, rule := range rules {
+		producers[rule.Output] = append(producers[rule.Output], i)
+	}
+
+	for i, rule := range rules {
+		issues = append(issues, lintExpression(i, rule, ruleFunctions)...)
+	}
+
+	ids := make(map[string][]int)
+	for i, rule := range rules {
+		if rule.ID != "" {
+			ids[rule.ID] = append(ids[rule.ID], i)
+		}
+	}
+	for i, rule := range rules {
+		if rule.ID == "" || ids[rule.ID][0] != i || len(ids[rule.ID]) < 2 {
+			continue
+		}
+		issues = append(issues, Issue{
+			Kind:     IssueDuplicateID,
+			Severity: SeverityError,
+			Rules:    ids[rule.ID],
+			Message:  fmt.Sprintf("id %q is used by %d rules", rule.ID, len(ids[rule.ID])),
+		})
+	}
+
+	var schema map[string]struct{}
+	if opts.Facts != nil {
+		schema = make(map[string]struct{}, len(opts.Facts))
+		for _, fact := range opts.Facts {
+			schema[fact] = struct{}{}
+		}
+
+		reported := make(map[string]struct{})
+		for i, rule := range rules {
+			for _, input := range rule.Input {
+				_, known := schema[input]
+				_, done := reported[input]
+				if known || done || len(producers[input]) > 0 {
+					continue
+				}
+				reported[input] = struct{}{}
+				issues = append(issues, Issue{
+					Kind:     IssueUnproducedInput,
+					Severity: SeverityWarning,
+					Rules:    []int{i},
+					Fact:     input,
+					Message:  fmt.Sprintf("input %q is neither produced by a rule nor an initial fact", input),
+				})
+			}
+		}
+	}
+
+	for _, output := range sortedOutputs(producers) {
+		if len(producers[output]) < 2 {
+			continue
+		}
+		issues = append(issues, Issue{
+			Kind:     IssueDuplicateOutput,
+			Severity: SeverityWarning,
+			Rules:    producers[output],
+			Fact:     output,
+			Message:  fmt.Sprintf("output %q is produced by %d rules", output, len(producers[output])),
+		})
+	}
+
+	for _, cycle := range findCycles(rules) {
+		issues = append(issues, Issue{
+			Kind:     IssueCycle,
+			Severity: SeverityWarning,
+			Rules:    cycle,
+			Message:  fmt.Sprintf("rules %v depend on each other", cycle),
+		})
+	}
+
+	known := reachableFacts(rules, producers, schema)
+	for i, rule := range rules {
+		if !hasAll(known, rule.Input) {
+			issues = append(issues, Issue{
+				Kind:     IssueUnreachableRule,
+				Severity: SeverityWarning,
+				Rules:    []int{i},
+				Fact:     rule.Output,
+				Message:  "rule can never fire since some of its inputs are never known",
+			})
+		}
+	}
+
+	targets := opts.Targets
+	if len(targets) == 0 {
+		targets = []string{DefaultTarget}
+	}
+	for _, target := range targets {
+		if _, ok := known[target]; !ok {
+			issues = append(issues, Issue{
+				Kind:     IssueUnreachableTarget,
+				Severity: SeverityError,
+				Rules:    producers[target],
+				Fact:     target,
+				Message:  fmt.Sprintf("target %q can never be produced", target),
+			})
+		}
+	}
+
+	for k := range issues {
+		issues[k].RuleIDs = ruleIDs(rules, issues[k].Rules)
+	}
+	return issues
+}
+
+// ruleIDs returns the ID of every rule of indexes, or nil when none of them has an ID
+func ruleIDs(rules []Rule, indexes []int) []string {
+	var ids []string
+	for k, i := range indexes {
+		if rules[i].ID == "" {
+			continue
+		}
+		if ids == nil {
+			ids = make([]string, len(indexes))
+		}
+		ids[k] = rules[i].ID
+	}
+	return ids
+}
+
+// lintExpression reports parse errors, unknown functions and undeclared variables of a single rule
+func lintExpression(i int, rule Rule, ruleFunctions map[string]RuleFunction) []Issue {
+	var issues []Issue
+
+	functions := make(map[string]govaluate.ExpressionFunction, len(ruleFunctions)+len(builtinFunctions))
+	for key, value := range ruleFunctions {
+		functions[key] = govaluate.ExpressionFunction(value)
+	}
+	for key, value := range builtinFunctions {
+		functions[key] = govaluate.ExpressionFunction(value)
+	}
+
+	// Unknown functions are replaced by stubs, so the rest of the expression can still be checked
+	for _, name := range stubFunctions(rule.Expression, functions) {
+		issues = append(issues, Issue{
+			Kind:     IssueUnknownFunction,
+			Severity: SeverityError,
+			Rules:    []int{i},
+			Message:  fmt.Sprintf("function %q is not a registered rule function", name),
+		})
+	}
+
+	expression, err := govaluate.NewEvaluableExpressionWithFunctions(rule.Expression, functions)
+	if err != nil {
+		return append(issues, Issue{
+			Kind:     IssueParse,
+			Severity: SeverityError,
+			Rules:    []int{i},
+			Message:  err.Error(),
+		})
+	}
+
+	declared := make(map[string]struct{}, len(rule.Input))
+	for _, input := range rule.Input {
+		declared[input] = struct{}{}
+	}
+	for _, variable := range expression.Vars() {
+		if _, ok := declared[variable]; ok {
+			continue
+		}
+		declared[variable] = struct{}{}
+		issues = append(issues, Issue{
+			Kind:     IssueUndeclaredVariable,
+			Severity: SeverityError,
+			Rules:    []int{i},
+			Fact:     variable,
+			Message:  fmt.Sprintf("variable %q is used in the expression but not listed in input", variable),
+		})
+	}
+
+	return issues
+}
+
+// stubFunctions adds a stub to functions for every function the expression calls but functions lacks,
+// and returns their names. The IN operator is followed by a parenthesis too, it is not a function.
+func stubFunctions(expression string, functions map[string]govaluate.ExpressionFunction) []string {
+	var names []string
+	stripped := literalPattern.ReplaceAllString(expression, "")
+	for _, match := range functionCallPattern.FindAllStringSubmatch(stripped, -1) {
+		name := match[1]
+		if _, ok := functions[name]; ok || name == "in" || name == "IN" {
+			continue
+		}
+		functions[name] = func(...interface{}) (interface{}, error) { return nil, nil }
+		names = append(names, name)
+	}
+	return names
+}
+
+// reachableFacts returns every fact that can be known starting from the schema, by firing rules forward.
+// A nil schema assumes every fact no rule produces is an initial fact.
+func reachableFacts(rules []Rule, producers map[string][]int, schema map[string]struct{}) map[string]struct{} {
+	known := make(map[string]struct{})
+	if schema != nil {
+		for fact := range schema {
+			known[fact] = struct{}{}
+		}
+	} else {
+		for _, rule := range rules {
+			for _, input := range rule.Input {
+				if len(producers[input]) == 0 {
+					known[input] = struct{}{}
+				}
+			}
+		}
+	}
+
+	// same propagation as the run time scheduler, a rule fires once its last missing input is known
+	dependents := make(map[string][]int)
+	missing := make([]int, len(rules))
+	for i, rule := range rules {
+		seen := make(map[string]struct{}, len(rule.Input))
+		for _, input := range rule.Input {
+			if _, ok := seen[input]; ok {
+				continue
+			}
+			seen[input] = struct{}{}
+			dependents[input] = append(dependents[input], i)
+			missing[i]++
+		}
+	}
+
+	var queue []string
+	for fact := range known {
+		queue = append(queue, fact)
+	}
+	for i, rule := range rules {
+		if missing[i] == 0 {
+			queue = append(queue, rule.Output)
+		}
+	}
+	released := make(map[string]struct{})
+	for len(queue) > 0 {
+		fact := queue[0]
+		queue = queue[1:]
+		if _, ok := released[fact]; ok {
+			continue
+		}
+		released[fact] = struct{}{}
+		known[fact] = struct{}{}
+		for _, i := range dependents[fact] {
+			missing[i]--
+			if missing[i] == 0 {
+				queue = append(queue, rules[i].Output)
+			}
+		}
+	}
+	return known
+}
+
+// findCycles returns the rules depending on each other through their input and output,
+// as strongly connected components of the rule graph
+func findCycles(rules []Rule) [][]int {
+	dependents := make(map[string][]int)
+	for i, rule := range rules {
+		for _, input := range rule.Input {
+			dependents[input] = append(dependents[input], i)
+		}
+	}
+
+	var cycles [][]int
+	var stack []int
+	var counter int
+	index := make([]int, len(rules))
+	lowlink := make([]int, len(rules))
+	onStack := make([]bool, len(rules))
+
+	var connect func(i int)
+	connect = func(i int) {
+		counter++
+		index[i], lowlink[i] = counter, counter
+		stack = append(stack, i)
+		onStack[i] = true
+
+		selfLoop := false
+		for _, j := range dependents[rules[i].Output] {
+			if j == i {
+				selfLoop = true
+			}
+			if index[j] == 0 {
+				connect(j)
+				if lowlink[j] < lowlink[i] {
+					lowlink[i] = lowlink[j]
+				}
+			} else if onStack[j] && index[j] < lowlink[i] {
+				lowlink[i] = index[j]
+			}
+		}
+
+		if lowlink[i] != index[i] {
+			return
+		}
+
+		var component []int
+		for {
+			j := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[j] = false
+			component = append(component, j)
+			if j == i {
+				break
+			}
+		}
+		if len(component) > 1 || selfLoop {
+			sort.Ints(component)
+			cycles = append(cycles, component)
+		}
+	}
+
+	for i := range rules {
+		if index[i] == 0 {
+			connect(i)
+		}
+	}
+
+	sort.Slice(cycles, func(a, b int) bool {
+		return cycles[a][0] < cycles[b][0]
+	})
+	return cycles
+}
+
+func hasAll(known map[string]struct{}, keys []string) bool {
+	for _, key := range keys {
+		if _, ok := known[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedOutputs(producers map[string][]int) []string {
+	outputs := make([]string, 0, len(producers))
+	for output := range producers {
+		outputs = append(outputs, output)
+	}
+	sort.Strings(outputs)
+	return outputs
+}
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+func (i Issue) String() string {
+	if len(i.Rules) == 0 {
+		return fmt.Sprintf("%s: %s: %s", i.Severity, i.Kind, i.Message)
+	}
+	names := make([]string, len(i.Rules))
+	for k, rule := range i.Rules {
+		var id string
+		if k < len(i.RuleIDs) {
+			id = i.RuleIDs[k]
+		}
+		names[k] = ruleName(rule, id)
+	}
+	return fmt.Sprintf("%s: %s: %s: %s", i.Severity, i.Kind, strings.Join(names, ", "), i.Message)
+}
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, issue := range e {
+		msgs[i] = issue.String()
+	}
+	return strings.Join(msgs, "; ")
+}
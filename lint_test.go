@@ -0,0 +1,156 @@
+package fished
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	ruleFunctions := map[string]RuleFunction{
+		"set": func(args ...interface{}) (interface{}, error) {
+			return args[0], nil
+		},
+	}
+
+	tc := []struct {
+		Name     string
+		Rules    []Rule
+		Options  LintOptions
+		Expected []Issue
+	}{
+		{
+			Name: "clean rule set",
+			Rules: []Rule{
+				{Input: []string{"example"}, Output: "result_end", Expression: "set(example) == 'killer'"},
+			},
+			Options: LintOptions{Facts: []string{"example"}},
+		},
+		{
+			Name: "in operator",
+			Rules: []Rule{
+				{Input: []string{"example"}, Output: "result_end", Expression: "example IN ('a', 'b') && set(example) in ('a')"},
+			},
+			Options: LintOptions{Facts: []string{"example"}},
+		},
+		{
+			Name: "rule ids",
+			Rules: []Rule{
+				{ID: "first", Input: []string{"example"}, Output: "result_end", Expression: "example"},
+				{Input: []string{"example"}, Output: "result_end", Expression: "example"},
+			},
+			Options: LintOptions{Facts: []string{"example"}},
+			Expected: []Issue{
+				{Kind: IssueDuplicateOutput, Severity: SeverityWarning, Rules: []int{0, 1}, RuleIDs: []string{"first", ""}, Fact: "result_end"},
+			},
+		},
+		{
+			Name: "builtin function",
+			Rules: []Rule{
+				{Input: []string{"example"}, Output: "result_end", Expression: "fished_hit_first(example == 'a', 'a', true, 'other')"},
+			},
+			Options: LintOptions{Facts: []string{"example"}},
+		},
+		{
+			Name: "parse error",
+			Rules: []Rule{
+				{Input: []string{"account_partner"}, Output: "result_end", Expression: "account_partner == 'hello : 'free' ? 'paid'"},
+			},
+			Expected: []Issue{
+				{Kind: IssueParse, Severity: SeverityError, Rules: []int{0}},
+			},
+		},
+		{
+			Name: "duplicate id",
+			Rules: []Rule{
+				{ID: "type", Input: []string{"example"}, Output: "account_type", Expression: "example"},
+				{ID: "type", Input: []string{"account_type"}, Output: "result_end", Expression: "account_type"},
+			},
+			Options: LintOptions{Facts: []string{"example"}},
+			Expected: []Issue{
+				{Kind: IssueDuplicateID, Severity: SeverityError, Rules: []int{0, 1}, RuleIDs: []string{"type", "type"}},
+			},
+		},
+		{
+			Name: "unknown function and undeclared variable",
+			Rules: []Rule{
+				{Input: []string{"example"}, Output: "result_end", Expression: "geo(example, region) && set('unknown(')"},
+			},
+			Expected: []Issue{
+				{Kind: IssueUnknownFunction, Severity: SeverityError, Rules: []int{0}},
+				{Kind: IssueUndeclaredVariable, Severity: SeverityError, Rules: []int{0}, Fact: "region"},
+			},
+		},
+		{
+			Name: "unproduced input, unreachable rule and target",
+			Rules: []Rule{
+				{Input: []string{"account_partner"}, Output: "account_type", Expression: "account_partner"},
+				{Input: []string{"account_type", "flight_type"}, Output: "result_end", Expression: "account_type == flight_type"},
+			},
+			Options: LintOptions{Facts: []string{"account_partner"}},
+			Expected: []Issue{
+				{Kind: IssueUnproducedInput, Severity: SeverityWarning, Rules: []int{1}, Fact: "flight_type"},
+				{Kind: IssueUnreachableRule, Severity: SeverityWarning, Rules: []int{1}, Fact: "result_end"},
+				{Kind: IssueUnreachableTarget, Severity: SeverityError, Rules: []int{1}, Fact: "result_end"},
+			},
+		},
+		{
+			Name: "duplicate output and cycle",
+			Rules: []Rule{
+				{Input: []string{"b"}, Output: "a", Expression: "b"},
+				{Input: []string{"a"}, Output: "b", Expression: "a"},
+				{Input: []string{"seed"}, Output: "a", Expression: "seed"},
+				{Input: []string{"a", "b"}, Output: "result_end", Expression: "a && b"},
+			},
+			Expected: []Issue{
+				{Kind: IssueDuplicateOutput, Severity: SeverityWarning, Rules: []int{0, 2}, Fact: "a"},
+				{Kind: IssueCycle, Severity: SeverityWarning, Rules: []int{0, 1}},
+			},
+		},
+		{
+			Name: "cycle never seeded",
+			Rules: []Rule{
+				{Input: []string{"b"}, Output: "a", Expression: "b"},
+				{Input: []string{"a"}, Output: "b", Expression: "a"},
+			},
+			Options: LintOptions{Targets: []string{"a"}},
+			Expected: []Issue{
+				{Kind: IssueCycle, Severity: SeverityWarning, Rules: []int{0, 1}},
+				{Kind: IssueUnreachableRule, Severity: SeverityWarning, Rules: []int{0}, Fact: "a"},
+				{Kind: IssueUnreachableRule, Severity: SeverityWarning, Rules: []int{1}, Fact: "b"},
+				{Kind: IssueUnreachableTarget, Severity: SeverityError, Rules: []int{0}, Fact: "a"},
+			},
+		},
+	}
+
+	for _, test := range tc {
+		t.Run(test.Name, func(t *testing.T) {
+			issues := Lint(test.Rules, ruleFunctions, test.Options)
+
+			// messages are meant for humans, only check the structured fields
+			for i := range issues {
+				assert.NotEmpty(t, issues[i].Message)
+				issues[i].Message = ""
+			}
+			assert.Equal(t, test.Expected, issues)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	rules := []Rule{
+		{Input: []string{"b"}, Output: "a", Expression: "b"},
+		{Input: []string{"seed"}, Output: "a", Expression: "seed"},
+		{Input: []string{"a"}, Output: "result_end", Expression: "a"},
+	}
+	assert.Nil(t, Validate(rules, nil, LintOptions{}))
+
+	err := Validate(rules, nil, LintOptions{Facts: []string{"b"}, Targets: []string{"result_end", "other"}})
+	if assert.IsType(t, ValidationError{}, err) {
+		assert.Len(t, err.(ValidationError), 1)
+		assert.Equal(t, `error: unreachable-target: target "other" can never be produced`, err.Error())
+	}
+
+	err = Validate([]Rule{{ID: "undeclared", Input: []string{"a"}, Output: "result_end", Expression: "a && b"}}, nil, LintOptions{})
+	assert.Equal(t, `error: undeclared-variable: rule 0 "undeclared": variable "b" is used in the expression but not listed in input`, err.Error())
+}
@@ -0,0 +1,372 @@
+package fished
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// FormatVersion is the latest version of the rule set file format, files without format_version are version 1
+const FormatVersion = 1
+
+// Formats of rule set files
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+)
+
+type (
+	// Format is the syntax a rule set file is written in
+	Format string
+
+	// RuleSetFile is the versioned envelope rule sets are stored in, rules are kept under "data".
+	// Target is the default target of the rule set, used by RunDefault once compiled into an engine.
+	// Comment is written as the comment heading the file in YAML and TOML.
+	RuleSetFile struct {
+		FormatVersion int    `json:"format_version,omitempty" yaml:"format_version,omitempty" toml:"format_version,omitzero"`
+		Name          string `json:"name,omitempty" yaml:"name,omitempty" toml:"name,omitempty"`
+		Version       string `json:"version,omitempty" yaml:"version,omitempty" toml:"version,omitempty"`
+		Target        string `json:"target,omitempty" yaml:"target,omitempty" toml:"target,omitempty"`
+		Comment       string `json:"comment,omitempty" yaml:"-" toml:"-"`
+		Rules         []Rule `json:"data" yaml:"data" toml:"data"`
+	}
+
+	// LoadError reports why a rule set file could not be loaded and where, Line and Column start at 1.
+	// Path is only set by LoadRuleSetFile.
+	LoadError struct {
+		Path   string
+		Line   int
+		Column int
+		Err    error
+	}
+
+	// jsonField is a member of a JSON object, offsets are from the start of the document
+	jsonField struct {
+		key         string
+		keyOffset   int64
+		value       json.RawMessage
+		valueOffset int64
+	}
+)
+
+// ruleFields lists the fields of a Rule in each format, any other field in a rule is rejected
+var ruleFields = map[Format]map[string]struct{}{
+	FormatJSON: tagFields(reflect.TypeOf(Rule{}), "json"),
+	FormatYAML: tagFields(reflect.TypeOf(Rule{}), "yaml"),
+	FormatTOML: tagFields(reflect.TypeOf(Rule{}), "toml"),
+}
+
+// LoadRuleSet reads a JSON rule set file. Unknown fields are rejected and every error is a *LoadError
+// pointing to the line and column at fault.
+func LoadRuleSet(r io.Reader) (*RuleSetFile, error) {
+	return LoadRuleSetFormat(r, FormatJSON)
+}
+
+// LoadRuleSetFormat reads a rule set file written in format like LoadRuleSet.
+// YAML and TOML errors may only know their line, Column is then 0.
+func LoadRuleSetFormat(r io.Reader, format Format) (*RuleSetFile, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatJSON:
+		return decodeRuleSetFile(data)
+	case FormatYAML:
+		return decodeYAMLRuleSetFile(data)
+	case FormatTOML:
+		return decodeTOMLRuleSetFile(data)
+	}
+	return nil, fmt.Errorf("fished: unknown rule set format %q", format)
+}
+
+// LoadRuleSetFile reads the rule set file at path, its format is detected from the extension:
+// .yaml or .yml for YAML, .toml for TOML, JSON otherwise
+func LoadRuleSetFile(path string) (*RuleSetFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	f, err := LoadRuleSetFormat(file, formatOf(path))
+	var loadErr *LoadError
+	if errors.As(err, &loadErr) {
+		loadErr.Path = path
+	}
+	return f, err
+}
+
+// Write writes the rule set file in format. Comments are written as YAML and TOML comments
+// and multi-line expressions as multi-line strings.
+func (f *RuleSetFile) Write(w io.Writer, format Format) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		// expressions are full of && and <, escaping them would only hurt readability
+		enc.SetEscapeHTML(false)
+		return enc.Encode(f)
+	case FormatYAML:
+		return writeYAMLRuleSetFile(w, f)
+	case FormatTOML:
+		return writeTOMLRuleSetFile(w, f)
+	}
+	return fmt.Errorf("fished: unknown rule set format %q", format)
+}
+
+// WriteFile writes the rule set file at path, in the format detected from the extension like LoadRuleSetFile
+func (f *RuleSetFile) WriteFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := f.Write(file, formatOf(path)); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// formatOf detects the format of a rule set file from its extension
+func formatOf(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatJSON
+}
+
+// checkFormatVersion fills in the format version of files without one and rejects unsupported versions
+func (f *RuleSetFile) checkFormatVersion() error {
+	if f.FormatVersion == 0 {
+		f.FormatVersion = 1
+	}
+	if f.FormatVersion < 1 || f.FormatVersion > FormatVersion {
+		return fmt.Errorf("unsupported format version %d", f.FormatVersion)
+	}
+	return nil
+}
+
+// Compile compiles the rules of the file like Compile, keeping its name, version and target
+func (f *RuleSetFile) Compile(ruleFunctions map[string]RuleFunction) (*RuleSet, error) {
+	rs, err := Compile(f.Rules, ruleFunctions)
+	if err != nil {
+		return nil, err
+	}
+	rs.name, rs.version, rs.target, rs.comment = f.Name, f.Version, f.Target, f.Comment
+	return rs, nil
+}
+
+func decodeRuleSetFile(data []byte) (*RuleSetFile, error) {
+	// syntax errors are caught up front, so the walk below only deals with valid JSON
+	if err := json.Unmarshal(data, new(json.RawMessage)); err != nil {
+		return nil, newLoadError(data, 0, err)
+	}
+
+	fields, ok := objectFields(data, 0)
+	if !ok {
+		return nil, newLoadError(data, 0, errors.New("rule set must be a JSON object"))
+	}
+
+	f := &RuleSetFile{}
+	for _, field := range fields {
+		var value interface{}
+		switch field.key {
+		case "format_version":
+			value = &f.FormatVersion
+		case "name":
+			value = &f.Name
+		case "version":
+			value = &f.Version
+		case "target":
+			value = &f.Target
+		case "comment":
+			value = &f.Comment
+		case "data":
+			rules, err := decodeRules(data, field)
+			if err != nil {
+				return nil, err
+			}
+			f.Rules = rules
+			continue
+		default:
+			return nil, newLoadError(data, field.keyOffset, fmt.Errorf("unknown field %q", field.key))
+		}
+
+		if err := json.Unmarshal(field.value, value); err != nil {
+			return nil, newLoadError(data, field.valueOffset, err)
+		}
+		if field.key == "format_version" {
+			if err := f.checkFormatVersion(); err != nil {
+				return nil, newLoadError(data, field.valueOffset, err)
+			}
+		}
+	}
+
+	if f.FormatVersion == 0 {
+		f.FormatVersion = 1
+	}
+	return f, nil
+}
+
+// decodeRules decodes the rules of the data field, one by one so errors point to the rule at fault
+func decodeRules(data []byte, field jsonField) ([]Rule, error) {
+	if bytes.Equal(field.value, []byte("null")) {
+		return nil, nil
+	}
+	elements, ok := arrayElements(field.value, field.valueOffset)
+	if !ok {
+		return nil, newLoadError(data, field.valueOffset, errors.New("data must be an array of rules"))
+	}
+
+	rules := make([]Rule, len(elements))
+	for i, element := range elements {
+		fields, ok := objectFields(element.value, element.valueOffset)
+		if !ok {
+			return nil, newLoadError(data, element.valueOffset, fmt.Errorf("rule %d must be an object", i))
+		}
+		for _, field := range fields {
+			if _, ok := ruleFields[FormatJSON][field.key]; !ok {
+				return nil, newLoadError(data, field.keyOffset, fmt.Errorf("rule %d: unknown field %q", i, field.key))
+			}
+		}
+		if err := json.Unmarshal(element.value, &rules[i]); err != nil {
+			return nil, newLoadError(data, element.valueOffset, fmt.Errorf("rule %d: %w", i, err))
+		}
+	}
+	return rules, nil
+}
+
+// objectFields splits a valid JSON object into its fields, it returns false when raw is not an object
+func objectFields(raw []byte, base int64) ([]jsonField, bool) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if token, err := dec.Token(); err != nil || token != json.Delim('{') {
+		return nil, false
+	}
+
+	var fields []jsonField
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, false
+		}
+		keyEnd := dec.InputOffset()
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, false
+		}
+		fields = append(fields, jsonField{
+			key:         token.(string),
+			keyOffset:   base + quoteStart(raw, keyEnd),
+			value:       value,
+			valueOffset: base + dec.InputOffset() - int64(len(value)),
+		})
+	}
+	return fields, true
+}
+
+// arrayElements splits a valid JSON array into its elements, it returns false when raw is not an array
+func arrayElements(raw []byte, base int64) ([]jsonField, bool) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if token, err := dec.Token(); err != nil || token != json.Delim('[') {
+		return nil, false
+	}
+
+	var elements []jsonField
+	for dec.More() {
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, false
+		}
+		elements = append(elements, jsonField{
+			value:       value,
+			valueOffset: base + dec.InputOffset() - int64(len(value)),
+		})
+	}
+	return elements, true
+}
+
+// quoteStart returns the offset of the opening quote of the string ending at end
+func quoteStart(raw []byte, end int64) int64 {
+	for i := end - 2; i > 0; i-- {
+		if raw[i] == '"' && raw[i-1] != '\\' {
+			return i
+		}
+	}
+	return 0
+}
+
+// tagFields returns the names of the fields of a struct type under the given struct tag
+func tagFields(t reflect.Type, tag string) map[string]struct{} {
+	fields := make(map[string]struct{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get(tag), ",")[0]
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		if name != "-" {
+			fields[name] = struct{}{}
+		}
+	}
+	return fields
+}
+
+// newLoadError locates err in data, offset is where the JSON value at fault starts.
+// Offsets reported by encoding/json errors are relative to that value.
+func newLoadError(data []byte, offset int64, err error) *LoadError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset += syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset += typeErr.Offset
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	column := int(offset) - bytes.LastIndexByte(data[:offset], '\n')
+	return &LoadError{
+		Line:   line,
+		Column: column,
+		Err:    err,
+	}
+}
+
+func (e *LoadError) Error() string {
+	position := e.Path
+	switch {
+	case e.Path != "" && e.Line > 0 && e.Column > 0:
+		position = fmt.Sprintf("%s:%d:%d", e.Path, e.Line, e.Column)
+	case e.Path != "" && e.Line > 0:
+		position = fmt.Sprintf("%s:%d", e.Path, e.Line)
+	case e.Line > 0 && e.Column > 0:
+		position = fmt.Sprintf("line %d, column %d", e.Line, e.Column)
+	case e.Line > 0:
+		position = fmt.Sprintf("line %d", e.Line)
+	}
+	if position == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", position, e.Err)
+}
+
+// Unwrap returns the cause of the load failure
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,302 @@
+package fished
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadRuleSet(t *testing.T) {
+	t.Run("envelope", func(t *testing.T) {
+		f, err := LoadRuleSetFile("./test/tc7.json")
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, 1, f.FormatVersion)
+		assert.Equal(t, "eligibility", f.Name)
+		assert.Equal(t, "2.1.0", f.Version)
+		assert.Equal(t, "isEligible", f.Target)
+		if assert.Len(t, f.Rules, 2) {
+			assert.Equal(t, "account-type", f.Rules[0].ID)
+			assert.Equal(t, []string{"eligibility"}, f.Rules[1].Tags)
+		}
+
+		rs, err := f.Compile(nil)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, "eligibility", rs.Name())
+		assert.Equal(t, "2.1.0", rs.Version())
+		assert.Equal(t, "isEligible", rs.Target())
+
+		e := New()
+		defer e.Close()
+		e.SetRuleSet(rs)
+		e.SetFacts(map[string]interface{}{"account_partner": "hello", "account_region": "ID"})
+		res, errs := e.RunDefault()
+		assert.Nil(t, errs)
+		assert.Equal(t, true, res)
+	})
+
+	t.Run("legacy file without envelope fields", func(t *testing.T) {
+		f, err := LoadRuleSetFile("./test/tc1.json")
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, 1, f.FormatVersion)
+		assert.Equal(t, "", f.Name)
+		assert.Len(t, f.Rules, 6)
+	})
+
+	tc := []struct {
+		Name     string
+		Input    string
+		Line     int
+		Column   int
+		Expected string
+	}{
+		{
+			Name:     "unknown envelope field",
+			Input:    "{\n  \"name\": \"x\",\n  \"rules\": []\n}",
+			Line:     3,
+			Column:   3,
+			Expected: `unknown field "rules"`,
+		},
+		{
+			Name:     "unknown rule field",
+			Input:    "{\"data\": [\n  {\"input\": [], \"output\": \"a\", \"expression\": \"1\"},\n  {\"input\": [], \"ouptut\": \"a\"}\n]}",
+			Line:     3,
+			Column:   17,
+			Expected: `rule 1: unknown field "ouptut"`,
+		},
+		{
+			Name:     "wrong type",
+			Input:    "{\"data\": [\n  {\"input\": \"a\"}\n]}",
+			Line:     2,
+			Column:   16,
+			Expected: "cannot unmarshal string",
+		},
+		{
+			Name:     "invalid error policy",
+			Input:    "{\"data\": [\n  {\"on_error\": \"retry\"}\n]}",
+			Line:     2,
+			Column:   3,
+			Expected: `unknown error policy "retry"`,
+		},
+		{
+			Name:     "syntax error",
+			Input:    "{\n  \"data\": [\n    {\"input\": [}\n  ]\n}",
+			Line:     3,
+			Column:   17,
+			Expected: "invalid character '}'",
+		},
+		{
+			Name:     "unsupported format version",
+			Input:    "{\"format_version\": 2, \"data\": []}",
+			Line:     1,
+			Column:   20,
+			Expected: "unsupported format version 2",
+		},
+		{
+			Name:     "not an object",
+			Input:    "[]",
+			Line:     1,
+			Column:   1,
+			Expected: "rule set must be a JSON object",
+		},
+	}
+
+	for _, test := range tc {
+		t.Run(test.Name, func(t *testing.T) {
+			f, err := LoadRuleSet(strings.NewReader(test.Input))
+			assert.Nil(t, f)
+
+			var loadErr *LoadError
+			if !assert.True(t, errors.As(err, &loadErr)) {
+				return
+			}
+			assert.Equal(t, test.Line, loadErr.Line)
+			assert.Equal(t, test.Column, loadErr.Column)
+			assert.Contains(t, loadErr.Error(), test.Expected)
+		})
+	}
+}
+
+func TestLoadRuleSetFormats(t *testing.T) {
+	expected := &RuleSetFile{
+		FormatVersion: 1,
+		Name:          "eligibility",
+		Version:       "2.1.0",
+		Target:        "isEligible",
+		Comment:       "Eligibility rules for the partner campaign.\nOwned by the growth team.",
+		Rules: []Rule{
+			{
+				ID:         "account-type",
+				Name:       "Account type",
+				Input:      []string{"account_partner"},
+				Output:     "account_type",
+				Expression: "account_partner == 'hello' ? 'free' : 'paid'",
+				Comment:    "Partner accounts are free, everyone else pays",
+			},
+			{
+				ID:         "eligible",
+				Input:      []string{"account_type", "account_region"},
+				Output:     "isEligible",
+				Expression: "account_type == 'free' &&\n  account_region == 'ID'",
+				OnError:    ErrorFallback,
+				Fallback:   false,
+				Tags:       []string{"eligibility"},
+				Metadata:   map[string]interface{}{"owner": "growth", "reviewed": float64(2)},
+				Comment:    "Only free accounts in Indonesia\nare eligible",
+			},
+		},
+	}
+
+	for _, path := range []string{"./test/tc8.yaml", "./test/tc8.toml"} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			f, err := LoadRuleSetFile(path)
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, expected, f)
+
+			// the files are written the way Write formats them, so writing them back changes nothing
+			golden, _ := ioutil.ReadFile(path)
+			var buf bytes.Buffer
+			assert.Nil(t, f.Write(&buf, formatOf(path)))
+			assert.Equal(t, string(golden), buf.String())
+
+			rs, err := f.Compile(nil)
+			if !assert.Nil(t, err) {
+				return
+			}
+			e := New()
+			defer e.Close()
+			e.SetRuleSet(rs)
+			e.SetFacts(map[string]interface{}{"account_partner": "hello", "account_region": "ID"})
+			res, errs := e.RunDefault()
+			assert.Nil(t, errs)
+			assert.Equal(t, true, res)
+		})
+	}
+
+	for _, format := range []Format{FormatJSON, FormatYAML, FormatTOML} {
+		t.Run("round trip "+string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if !assert.Nil(t, expected.Write(&buf, format)) {
+				return
+			}
+			f, err := LoadRuleSetFormat(&buf, format)
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, expected, f)
+		})
+	}
+
+	t.Run("toml multi-line strings", func(t *testing.T) {
+		input := "[[data]]\noutput = \"a\"\nexpression = \"\"\"\nb\n# not a comment\n[[data]]\n\"\"\"\n\n" +
+			"# Second rule\n[[data]]\noutput = \"c\"\nexpression = '''\n# d\n'''\n"
+		f, err := LoadRuleSetFormat(strings.NewReader(input), FormatTOML)
+		if !assert.Nil(t, err) || !assert.Len(t, f.Rules, 2) {
+			return
+		}
+		assert.Equal(t, "", f.Comment)
+		assert.Equal(t, "b\n# not a comment\n[[data]]", f.Rules[0].Expression)
+		assert.Equal(t, "", f.Rules[0].Comment)
+		assert.Equal(t, "Second rule", f.Rules[1].Comment)
+	})
+
+	t.Run("write file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "rules.yml")
+		if !assert.Nil(t, expected.WriteFile(path)) {
+			return
+		}
+		f, err := LoadRuleSetFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, f)
+	})
+
+	tc := []struct {
+		Name     string
+		Format   Format
+		Input    string
+		Line     int
+		Column   int
+		Expected string
+	}{
+		{
+			Name:     "yaml unknown rule field",
+			Format:   FormatYAML,
+			Input:    "data:\n  - input: []\n    ouptut: a\n",
+			Line:     3,
+			Column:   5,
+			Expected: `rule 0: unknown field "ouptut"`,
+		},
+		{
+			Name:     "yaml comment is not a field",
+			Format:   FormatYAML,
+			Input:    "comment: rules\ndata: []\n",
+			Line:     1,
+			Column:   1,
+			Expected: `unknown field "comment"`,
+		},
+		{
+			Name:     "yaml wrong type",
+			Format:   FormatYAML,
+			Input:    "data:\n  - input: a\n",
+			Line:     2,
+			Column:   5,
+			Expected: "rule 0: yaml: unmarshal errors",
+		},
+		{
+			Name:     "yaml syntax error",
+			Format:   FormatYAML,
+			Input:    "data:\n  - input: [a\n",
+			Line:     1,
+			Expected: "did not find expected ',' or ']'",
+		},
+		{
+			Name:     "toml unknown rule field",
+			Format:   FormatTOML,
+			Input:    "[[data]]\ninput = []\nouptut = \"a\"\n",
+			Line:     3,
+			Expected: `unknown field "data.ouptut"`,
+		},
+		{
+			Name:     "toml syntax error",
+			Format:   FormatTOML,
+			Input:    "name = \"x\"\n[[data]]\ninput = [\n",
+			Line:     3,
+			Column:   10,
+			Expected: "unexpected EOF",
+		},
+		{
+			Name:     "toml unsupported format version",
+			Format:   FormatTOML,
+			Input:    "format_version = 3\n",
+			Line:     1,
+			Expected: "unsupported format version 3",
+		},
+	}
+
+	for _, test := range tc {
+		t.Run(test.Name, func(t *testing.T) {
+			f, err := LoadRuleSetFormat(strings.NewReader(test.Input), test.Format)
+			assert.Nil(t, f)
+
+			var loadErr *LoadError
+			if !assert.True(t, errors.As(err, &loadErr)) {
+				return
+			}
+			assert.Equal(t, test.Line, loadErr.Line)
+			assert.Equal(t, test.Column, loadErr.Column)
+			assert.Contains(t, loadErr.Error(), test.Expected)
+		})
+	}
+}
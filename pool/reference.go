@@ -64,13 +64,13 @@ func NewReferenceCountedPool(factory func(referenceCounter ReferenceCounter) Ref
 	p.pool = new(sync.Pool)
 	p.pool.New = func() interface{} {
 		// Incrementing allocated count
-		atomic.AddUint32(&p.allocated, 1)
+		id := atomic.AddUint32(&p.allocated, 1)
 		c := factory(ReferenceCounter{
 			count:       new(uint32),
 			destination: p.pool,
 			released:    &p.returned,
 			reset:       reset,
-			id:          p.allocated,
+			id:          id,
 		})
 		return c
 	}
@@ -88,5 +88,9 @@ func (p *ReferenceCountedPool) Get() ReferenceCountable {
 
 // Stats Method to return reference counted pool stats
 func (p *ReferenceCountedPool) Stats() map[string]interface{} {
-	return map[string]interface{}{"allocated": p.allocated, "referenced": p.referenced, "returned": p.returned}
+	return map[string]interface{}{
+		"allocated":  atomic.LoadUint32(&p.allocated),
+		"referenced": atomic.LoadUint32(&p.referenced),
+		"returned":   atomic.LoadUint32(&p.returned),
+	}
 }
@@ -0,0 +1,128 @@
+package fished
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// RunResult is the detailed outcome of a run
+	RunResult struct {
+		Target string
+		Value  interface{}
+		Found  bool
+		// Targets holds the value of the target and of every target given WithTargets which has been produced
+		Targets map[string]interface{}
+		// Initial and Inferred are only set WithDerivedFacts. Initial holds the facts the run started with,
+		// Inferred the facts whose final value was produced by a rule.
+		Initial  map[string]interface{}
+		Inferred map[string]interface{}
+		Errors   MultiError
+		// WhyNot explains why the target is missing, nil when the target was found or the run was aborted
+		WhyNot *Diagnosis
+	}
+
+	// Diagnosis explains why a fact was never produced
+	Diagnosis struct {
+		Fact string
+		// Rules lists every rule able to produce the fact, it is empty when no rule produces it
+		Rules []*RuleDiagnosis
+	}
+
+	// RuleDiagnosis explains why a rule did not produce its output
+	RuleDiagnosis struct {
+		Rule       int
+		RuleID     string
+		Expression string
+		// Fired is true when the rule has been evaluated, it then either failed with Error or returned nil
+		Fired bool
+		Error error
+		// Missing explains every absent input which kept the rule from firing
+		Missing []*Diagnosis
+	}
+
+	diagnoser struct {
+		rs       *RuleSet
+		facts    map[string]interface{}
+		outcomes map[int]*EvalResult
+		seen     map[string]*Diagnosis
+	}
+)
+
+func newDiagnoser(rs *RuleSet, facts map[string]interface{}, outcomes map[int]*EvalResult) *diagnoser {
+	return &diagnoser{
+		rs:       rs,
+		facts:    facts,
+		outcomes: outcomes,
+		seen:     make(map[string]*Diagnosis),
+	}
+}
+
+// diagnose explains a missing fact, recursing through the missing inputs of its producers.
+// Every fact is diagnosed once, so shared inputs and cycles point to the same Diagnosis.
+func (d *diagnoser) diagnose(fact string) *Diagnosis {
+	if diagnosis, ok := d.seen[fact]; ok {
+		return diagnosis
+	}
+
+	diagnosis := &Diagnosis{
+		Fact: fact,
+	}
+	d.seen[fact] = diagnosis
+
+	for _, i := range d.rs.producers[fact] {
+		rd := &RuleDiagnosis{
+			Rule:       i,
+			RuleID:     d.rs.rules[i].ID,
+			Expression: d.rs.rules[i].Expression,
+		}
+		if outcome, ok := d.outcomes[i]; ok {
+			rd.Fired = true
+			rd.Error = outcome.Error
+		} else {
+			for _, input := range d.rs.inputs[i] {
+				if _, ok := d.facts[input]; !ok {
+					rd.Missing = append(rd.Missing, d.diagnose(input))
+				}
+			}
+		}
+		diagnosis.Rules = append(diagnosis.Rules, rd)
+	}
+	return diagnosis
+}
+
+// String renders the diagnosis as an indented tree
+func (d *Diagnosis) String() string {
+	var sb strings.Builder
+	d.render(&sb, 0, make(map[*Diagnosis]struct{}))
+	return sb.String()
+}
+
+func (d *Diagnosis) render(sb *strings.Builder, depth int, rendered map[*Diagnosis]struct{}) {
+	indent := strings.Repeat("  ", depth)
+	if _, ok := rendered[d]; ok {
+		fmt.Fprintf(sb, "%s%s: not produced (see above)\n", indent, d.Fact)
+		return
+	}
+	rendered[d] = struct{}{}
+
+	if len(d.Rules) == 0 {
+		fmt.Fprintf(sb, "%s%s: no rule produces it\n", indent, d.Fact)
+		return
+	}
+
+	fmt.Fprintf(sb, "%s%s: not produced\n", indent, d.Fact)
+	for _, rd := range d.Rules {
+		switch {
+		case rd.Error != nil:
+			fmt.Fprintf(sb, "%s  %s failed: %v\n", indent, ruleName(rd.Rule, rd.RuleID), rd.Error)
+		case rd.Fired:
+			fmt.Fprintf(sb, "%s  %s returned nil: %s\n", indent, ruleName(rd.Rule, rd.RuleID), rd.Expression)
+		default:
+			fmt.Fprintf(sb, "%s  %s did not fire: %s\n", indent, ruleName(rd.Rule, rd.RuleID), rd.Expression)
+			for _, missing := range rd.Missing {
+				missing.render(sb, depth+2, rendered)
+			}
+		}
+	}
+}
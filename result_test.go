@@ -0,0 +1,132 @@
+package fished
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunDetailed(t *testing.T) {
+	rules := []Rule{
+		{Input: []string{"account_partner"}, Output: "account_type", Expression: "account_partner == 'hello' ? 'free' : 'paid'"},
+		{Input: []string{"account_type"}, Output: "account_type_eligible", Expression: "account_type == 'free'"},
+		{Input: []string{"account_region"}, Output: "account_region_eligible", Expression: "geo(account_region)"},
+		{Input: []string{"flight_type"}, Output: "flight_type_eligible", Expression: "flight_type == 'free'"},
+		{Input: []string{"account_type_eligible", "account_region_eligible", "flight_type_eligible"}, Output: "result_end", Expression: "account_type_eligible && account_region_eligible && flight_type_eligible"},
+		{Input: []string{"account_type"}, Output: "result_end", Expression: "account_type == 'paid' ? false"},
+	}
+	geoErr := errors.New("geo lookup failed")
+
+	e := New()
+	defer e.Close()
+	e.Set(map[string]interface{}{
+		"account_partner": "hello",
+		"account_region":  "ID",
+	}, rules, map[string]RuleFunction{
+		"geo": func(args ...interface{}) (interface{}, error) {
+			return nil, geoErr
+		},
+	})
+
+	result := e.RunDetailed(context.Background(), DefaultTarget)
+	assert.Nil(t, result.Value)
+	assert.False(t, result.Found)
+	assert.Len(t, result.Errors, 1)
+	if !assert.NotNil(t, result.WhyNot) {
+		return
+	}
+
+	whyNot := result.WhyNot
+	assert.Equal(t, DefaultTarget, whyNot.Fact)
+	if assert.Len(t, whyNot.Rules, 2) {
+		assert.False(t, whyNot.Rules[0].Fired)
+		assert.Len(t, whyNot.Rules[0].Missing, 2)
+		assert.True(t, whyNot.Rules[1].Fired)
+		assert.Nil(t, whyNot.Rules[1].Error)
+	}
+
+	assert.Equal(t, ""+
+		"result_end: not produced\n"+
+		"  rule 4 did not fire: account_type_eligible && account_region_eligible && flight_type_eligible\n"+
+		"    account_region_eligible: not produced\n"+
+		"      rule 2 failed: geo lookup failed\n"+
+		"    flight_type_eligible: not produced\n"+
+		"      rule 3 did not fire: flight_type == 'free'\n"+
+		"        flight_type: no rule produces it\n"+
+		"  rule 5 returned nil: account_type == 'paid' ? false\n",
+		whyNot.String())
+
+	t.Run("found target", func(t *testing.T) {
+		result := e.RunDetailed(context.Background(), "account_type_eligible", GoalDirected())
+		assert.Equal(t, true, result.Value)
+		assert.True(t, result.Found)
+		assert.Nil(t, result.Errors)
+		assert.Nil(t, result.WhyNot)
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		e := New()
+		defer e.Close()
+		e.SetRules([]Rule{
+			{Input: []string{"b"}, Output: "a", Expression: "b"},
+			{Input: []string{"a"}, Output: "b", Expression: "a"},
+			{Input: []string{"a"}, Output: DefaultTarget, Expression: "a"},
+		})
+
+		result := e.RunDetailed(context.Background(), DefaultTarget)
+		assert.Equal(t, ""+
+			"result_end: not produced\n"+
+			"  rule 2 did not fire: a\n"+
+			"    a: not produced\n"+
+			"      rule 0 did not fire: b\n"+
+			"        b: not produced\n"+
+			"          rule 1 did not fire: a\n"+
+			"            a: not produced (see above)\n",
+			result.WhyNot.String())
+	})
+}
+
+func TestRunTargets(t *testing.T) {
+	rules := []Rule{
+		{Input: []string{"account_partner"}, Output: "account_type", Expression: "account_partner == 'hello' ? 'free' : 'paid'"},
+		{Input: []string{"account_type"}, Output: "isEligible", Expression: "account_type == 'free'"},
+		{Input: []string{"isEligible", "account_region"}, Output: DefaultTarget, Expression: "isEligible && account_region == 'ID'"},
+		{Input: []string{"account_type"}, Output: "account_region", Expression: "'SG'", Priority: -1},
+		{Input: []string{"flight_type"}, Output: "flight_type_eligible", Expression: "flight_type == 'free'"},
+	}
+
+	e := New()
+	defer e.Close()
+	e.SetRules(rules)
+	facts := map[string]interface{}{
+		"account_partner": "hello",
+		"account_region":  "ID",
+	}
+
+	result := e.RunDetailed(context.Background(), "isEligible", WithFacts(facts), WithTargets(DefaultTarget, "flight_type_eligible"), WithDerivedFacts())
+	assert.Nil(t, result.Errors)
+	assert.Equal(t, true, result.Value)
+	assert.Equal(t, map[string]interface{}{"isEligible": true, DefaultTarget: false}, result.Targets)
+	assert.Equal(t, facts, result.Initial)
+	assert.Equal(t, map[string]interface{}{
+		"account_type":   "free",
+		"isEligible":     true,
+		"account_region": "SG",
+		DefaultTarget:    false,
+	}, result.Inferred)
+
+	t.Run("goal directed", func(t *testing.T) {
+		result := e.RunDetailed(context.Background(), "account_type", WithFacts(facts), WithTargets("isEligible"), GoalDirected(), WithDerivedFacts())
+		assert.Equal(t, map[string]interface{}{"account_type": "free", "isEligible": true}, result.Targets)
+		assert.Equal(t, map[string]interface{}{"account_type": "free", "isEligible": true}, result.Inferred)
+	})
+
+	t.Run("without derived facts", func(t *testing.T) {
+		result := e.RunDetailed(context.Background(), DefaultTarget, WithFacts(facts))
+		assert.Nil(t, result.Initial)
+		assert.Nil(t, result.Inferred)
+		assert.Equal(t, map[string]interface{}{DefaultTarget: false}, result.Targets)
+	})
+}
@@ -0,0 +1,248 @@
+package fished
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/knetic/govaluate"
+)
+
+type (
+	// RuleSet is an immutable set of rules whose expressions are parsed once by Compile
+	RuleSet struct {
+		rules       []Rule
+		functions   map[string]govaluate.ExpressionFunction
+		expressions []*govaluate.EvaluableExpression
+		inputs      [][]string
+		dependents  map[string][]int
+		producers   map[string][]int
+		roots       []int
+		goals       sync.Map
+		// parseErrors holds the cause of every rule which can not be parsed, its expression is nil
+		parseErrors map[int]error
+		name        string
+		version     string
+		target      string
+		comment     string
+	}
+)
+
+// Compile parses every rule expression up front and builds the input to rule dependency index.
+// All parse errors are reported at once as a MultiError of RuleError.
+func Compile(rules []Rule, ruleFunctions map[string]RuleFunction) (*RuleSet, error) {
+	functions := make(map[string]govaluate.ExpressionFunction)
+	for key, value := range ruleFunctions {
+		functions[key] = expressionFunction(value)
+	}
+	rs, err := compile(rules, functions)
+	if err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// compile returns the rule set even when some rules can not be parsed, they keep their place in the dependency index
+// so an engine can still run them under its error policy. Only duplicated rule IDs make it fail.
+func compile(rules []Rule, functions map[string]govaluate.ExpressionFunction) (*RuleSet, error) {
+	var compileErr MultiError
+
+	// builtin functions are available to every rule set, under names rule functions can not take
+	var reserved []string
+	parsing := make(map[string]govaluate.ExpressionFunction, len(functions)+len(builtinFunctions))
+	for name, fn := range functions {
+		if strings.HasPrefix(name, reservedPrefix) {
+			reserved = append(reserved, name)
+		}
+		parsing[name] = fn
+	}
+	if reserved != nil {
+		sort.Strings(reserved)
+		return nil, MultiError{fmt.Errorf("%w: %s", ErrReservedFunction, strings.Join(reserved, ", "))}
+	}
+	for name, fn := range builtinFunctions {
+		parsing[name] = expressionFunction(fn)
+	}
+
+	rs := &RuleSet{
+		rules:       make([]Rule, len(rules)),
+		functions:   functions,
+		expressions: make([]*govaluate.EvaluableExpression, len(rules)),
+		inputs:      make([][]string, len(rules)),
+		dependents:  make(map[string][]int),
+		producers:   make(map[string][]int),
+	}
+	copy(rs.rules, rules)
+
+	// a rule set with duplicated IDs is never usable, whatever the error policy
+	ids := make(map[string]int, len(rules))
+	for i, rule := range rs.rules {
+		if rule.ID == "" {
+			continue
+		}
+		if first, ok := ids[rule.ID]; ok {
+			compileErr = append(compileErr, newRuleError(rs.rules, i, PhaseParse, fmt.Errorf("%w, also used by rule %d", ErrDuplicateRuleID, first)))
+			continue
+		}
+		ids[rule.ID] = i
+	}
+	if compileErr != nil {
+		return nil, compileErr
+	}
+
+	for i, rule := range rs.rules {
+		expression, err := govaluate.NewEvaluableExpressionWithFunctions(rule.Expression, parsing)
+		if err != nil {
+			compileErr = append(compileErr, newRuleError(rs.rules, i, PhaseParse, err))
+			if rs.parseErrors == nil {
+				rs.parseErrors = make(map[int]error)
+			}
+			rs.parseErrors[i] = err
+		}
+		rs.expressions[i] = expression
+		rs.producers[rule.Output] = append(rs.producers[rule.Output], i)
+
+		// Duplicated inputs are only counted once, a fact is either present or not
+		seen := make(map[string]struct{}, len(rule.Input))
+		for _, input := range rule.Input {
+			if _, ok := seen[input]; ok {
+				continue
+			}
+			seen[input] = struct{}{}
+			rs.inputs[i] = append(rs.inputs[i], input)
+			rs.dependents[input] = append(rs.dependents[input], i)
+		}
+		if len(rs.inputs[i]) == 0 {
+			rs.roots = append(rs.roots, i)
+		}
+	}
+
+	if compileErr != nil {
+		return rs, compileErr
+	}
+	return rs, nil
+}
+
+// parseErrorList returns the errors of the needed rules which can not be parsed, in rule order.
+// A nil needed means every rule.
+func (rs *RuleSet) parseErrorList(needed []bool) MultiError {
+	var errs MultiError
+	for i := range rs.rules {
+		if needed != nil && !needed[i] {
+			continue
+		}
+		if err, ok := rs.parseErrors[i]; ok {
+			errs = append(errs, newRuleError(rs.rules, i, PhaseParse, err))
+		}
+	}
+	return errs
+}
+
+// Rules returns a copy of the rules in the rule set
+func (rs *RuleSet) Rules() []Rule {
+	rules := make([]Rule, len(rs.rules))
+	copy(rules, rs.rules)
+	return rules
+}
+
+// Name returns the name of the rule set file it was compiled from
+func (rs *RuleSet) Name() string {
+	return rs.name
+}
+
+// Version returns the version of the rule set file it was compiled from
+func (rs *RuleSet) Version() string {
+	return rs.version
+}
+
+// Target returns the default target of the rule set, DefaultTarget unless its rule set file sets one
+func (rs *RuleSet) Target() string {
+	if rs.target == "" {
+		return DefaultTarget
+	}
+	return rs.target
+}
+
+// Len returns the number of rules in the rule set
+func (rs *RuleSet) Len() int {
+	return len(rs.rules)
+}
+
+// before reports whether rule a fires before rule b within a wave:
+// higher Priority first, then declaration order
+func (rs *RuleSet) before(a, b int) bool {
+	if pa, pb := rs.rules[a].Priority, rs.rules[b].Priority; pa != pb {
+		return pa > pb
+	}
+	return a < b
+}
+
+// sortWave puts the rules of a wave in firing order
+func (rs *RuleSet) sortWave(wave []int) {
+	sort.Slice(wave, func(a, b int) bool {
+		return rs.before(wave[a], wave[b])
+	})
+}
+
+// RulesFor returns the index of every rule the target can be derived from, in ascending order
+func (rs *RuleSet) RulesFor(target string) []int {
+	var rules []int
+	for i, ok := range rs.goal(target) {
+		if ok {
+			rules = append(rules, i)
+		}
+	}
+	return rules
+}
+
+// goal walks backward from target through rule outputs and inputs and marks the rules it needs.
+// The result is cached since the rule set never changes, only for targets some rule produces
+// so arbitrary targets can not grow the cache.
+func (rs *RuleSet) goal(target string) []bool {
+	if cached, ok := rs.goals.Load(target); ok {
+		return cached.([]bool)
+	}
+	if _, ok := rs.producers[target]; !ok {
+		return make([]bool, len(rs.rules))
+	}
+
+	needed := make([]bool, len(rs.rules))
+	visited := map[string]struct{}{target: {}}
+	stack := []string{target}
+	for len(stack) > 0 {
+		fact := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		for _, i := range rs.producers[fact] {
+			if needed[i] {
+				continue
+			}
+			needed[i] = true
+			for _, input := range rs.inputs[i] {
+				if _, ok := visited[input]; !ok {
+					visited[input] = struct{}{}
+					stack = append(stack, input)
+				}
+			}
+		}
+	}
+
+	cached, _ := rs.goals.LoadOrStore(target, needed)
+	return cached.([]bool)
+}
+
+// neededBy is goal for several targets at once, it marks the rules needed by any of them
+func (rs *RuleSet) neededBy(targets []string) []bool {
+	if len(targets) == 1 {
+		return rs.goal(targets[0])
+	}
+
+	needed := make([]bool, len(rs.rules))
+	for _, target := range targets {
+		for i, ok := range rs.goal(target) {
+			needed[i] = needed[i] || ok
+		}
+	}
+	return needed
+}
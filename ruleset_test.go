@@ -0,0 +1,206 @@
+package fished
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile(t *testing.T) {
+	rules := []Rule{
+		{
+			Input:      []string{"account_partner"},
+			Output:     "account_type",
+			Expression: "account_partner == 'hello : 'free' ? 'paid'",
+		},
+		{
+			Input:      []string{"account_type", "account_type"},
+			Output:     "result_end",
+			Expression: "account_type == 'free'",
+		},
+		{
+			Input:      []string{"example"},
+			Output:     "result_end",
+			Expression: "set(example)",
+		},
+	}
+
+	t.Run("every parse error is reported", func(t *testing.T) {
+		rs, err := Compile(rules, nil)
+		assert.Nil(t, rs)
+		if !assert.IsType(t, MultiError{}, err) {
+			return
+		}
+
+		compileErr := err.(MultiError)
+		if assert.Len(t, compileErr, 2) {
+			assert.Equal(t, 0, compileErr[0].(*RuleError).Rule)
+			assert.Equal(t, 2, compileErr[1].(*RuleError).Rule)
+			assert.Equal(t, "set(example)", compileErr[1].(*RuleError).Expression)
+			assert.Equal(t, PhaseParse, compileErr[1].(*RuleError).Phase)
+		}
+	})
+
+	t.Run("dependency index", func(t *testing.T) {
+		rs, err := Compile(rules[1:], map[string]RuleFunction{
+			"set": func(args ...interface{}) (interface{}, error) {
+				return args[0], nil
+			},
+		})
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		assert.Equal(t, 2, rs.Len())
+		assert.Equal(t, []string{"account_type"}, rs.inputs[0])
+		assert.Equal(t, map[string][]int{"account_type": {0}, "example": {1}}, rs.dependents)
+	})
+
+	t.Run("engine reports parse errors on run", func(t *testing.T) {
+		e := New()
+		defer e.Close()
+		e.Set(map[string]interface{}{"account_partner": "hello"}, rules[:2], nil)
+
+		res, errs := e.RunDefault()
+		assert.Nil(t, res)
+		if assert.Len(t, errs, 1) {
+			assert.Equal(t, 0, errs[0].(*RuleError).Rule)
+		}
+	})
+}
+
+func TestSetRuleSet(t *testing.T) {
+	rs, err := Compile([]Rule{
+		{
+			Input:      []string{"example"},
+			Output:     "result_end",
+			Expression: "set(example)",
+		},
+	}, map[string]RuleFunction{
+		"set": func(args ...interface{}) (interface{}, error) {
+			return args[0], nil
+		},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	for _, value := range []string{"killer", "queen"} {
+		e := New()
+		e.SetRuleSet(rs)
+		e.SetFacts(map[string]interface{}{"example": value})
+
+		res, errs := e.RunDefault()
+		assert.Nil(t, errs)
+		assert.Equal(t, value, res)
+		e.Close()
+	}
+
+	t.Run("nil rule set", func(t *testing.T) {
+		e := New()
+		defer e.Close()
+		assert.Equal(t, ErrNilRuleSet, e.SetRuleSet(nil))
+	})
+}
+
+func TestRulesFor(t *testing.T) {
+	rs, err := Compile([]Rule{
+		{Input: []string{"account_partner"}, Output: "account_type", Expression: "account_partner == 'hello' ? 'free' : 'paid'"},
+		{Input: []string{"account_partner"}, Output: "flight_type", Expression: "account_partner == 'hello' ? 'free' : 'paid'"},
+		{Input: []string{"flight_type"}, Output: "flight_type_eligible", Expression: "flight_type == 'free'"},
+		{Input: []string{"account_type"}, Output: "account_type_eligible", Expression: "account_type == 'free'"},
+		{Input: []string{"account_region"}, Output: "account_region_eligible", Expression: "account_region == 'ID'"},
+		{Input: []string{"account_type_eligible", "flight_type_eligible", "account_region_eligible"}, Output: "result_end", Expression: "account_type_eligible && flight_type_eligible && account_region_eligible"},
+	}, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5}, rs.RulesFor(DefaultTarget))
+	assert.Equal(t, []int{0, 3}, rs.RulesFor("account_type_eligible"))
+	assert.Equal(t, []int{4}, rs.RulesFor("account_region_eligible"))
+	assert.Nil(t, rs.RulesFor("unknown"))
+
+	// only targets produced by a rule are cached
+	assert.Equal(t, make([]bool, 6), rs.goal("unknown"))
+	_, cached := rs.goals.Load("unknown")
+	assert.False(t, cached)
+	rs.goal(DefaultTarget)
+	_, cached = rs.goals.Load(DefaultTarget)
+	assert.True(t, cached)
+}
+
+func TestRuleID(t *testing.T) {
+	rules := []Rule{
+		{
+			ID:          "account-type",
+			Name:        "Account type",
+			Description: "Partner accounts are free",
+			Input:       []string{"account_partner"},
+			Output:      "account_type",
+			Expression:  "account_partner == 'hello' ? 'free' : 'paid'",
+			Tags:        []string{"account"},
+			Metadata:    map[string]interface{}{"owner": "growth", "version": float64(2)},
+		},
+		{ID: "region", Input: []string{"account_region"}, Output: "account_region_eligible", Expression: "geo(account_region)"},
+		{Input: []string{"account_type", "account_region_eligible"}, Output: DefaultTarget, Expression: "account_type == 'free' && account_region_eligible"},
+	}
+
+	t.Run("json round trip", func(t *testing.T) {
+		encoded, err := json.Marshal(rules[0])
+		if !assert.Nil(t, err) {
+			return
+		}
+		var rule Rule
+		assert.Nil(t, json.Unmarshal(encoded, &rule))
+		assert.Equal(t, rules[0], rule)
+	})
+
+	t.Run("duplicate ids", func(t *testing.T) {
+		duplicated := append([]Rule{{ID: "region", Input: []string{"account_region"}, Output: "account_region", Expression: "account_region"}}, rules...)
+		rs, err := Compile(duplicated, nil)
+		assert.Nil(t, rs)
+		assert.True(t, errors.Is(err, ErrDuplicateRuleID))
+
+		e := New()
+		defer e.Close()
+		e.SetRules(duplicated)
+		_, errs := e.RunDefault()
+		if assert.Len(t, errs, 1) {
+			assert.Equal(t, "rule 2 \"region\": parse error: fished: duplicate rule id, also used by rule 0", errs[0].Error())
+		}
+	})
+
+	t.Run("errors, traces and stats", func(t *testing.T) {
+		e := New()
+		defer e.Close()
+		e.Set(map[string]interface{}{"account_partner": "hello", "account_region": "ID"}, rules, map[string]RuleFunction{
+			"geo": func(args ...interface{}) (interface{}, error) {
+				return nil, errors.New("geo lookup failed")
+			},
+		})
+
+		var trace Trace
+		for i := 0; i < 3; i++ {
+			_, errs := e.RunContext(context.Background(), DefaultTarget, WithTrace(&trace))
+			if assert.Len(t, errs, 1) {
+				assert.Equal(t, "region", errs[0].(*RuleError).RuleID)
+				assert.Equal(t, "rule 1 \"region\": function error: geo lookup failed", errs[0].Error())
+			}
+		}
+		assert.Equal(t, "account-type", trace.Steps[0].RuleID)
+		assert.Equal(t, "account_type = free (rule 0 \"account-type\", wave 1: account_partner == 'hello' ? 'free' : 'paid')\n"+
+			"  account_partner = hello (initial fact)\n", trace.Explain("account_type").String())
+
+		result := e.RunDetailed(context.Background(), DefaultTarget)
+		assert.Equal(t, "region", result.WhyNot.Rules[0].Missing[0].Rules[0].RuleID)
+
+		assert.Equal(t, map[string]RuleStats{
+			"account-type": {Evaluated: 4},
+			"region":       {Evaluated: 4, Failed: 4},
+		}, e.Stats().Rules)
+	})
+}
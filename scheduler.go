@@ -0,0 +1,117 @@
+package fished
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// scheduler evaluates the jobs of every run of an Engine on a fixed set of workers.
+	// Jobs are queued in a bounded FIFO, so submitting blocks once the queue is full, and every run
+	// may only have window jobs queued or evaluating at once, so a large run can not starve the others.
+	scheduler struct {
+		evaluated uint64
+		busy      int64
+		active    int64
+		jobCh     chan *Job
+		size      int
+		window    int
+		workers   sync.WaitGroup
+		rules     sync.Map
+	}
+
+	// Stats is a snapshot of the engine scheduler.
+	// Busy is the number of workers evaluating a job, Evaluated the number of jobs evaluated since the engine was created.
+	Stats struct {
+		Workers       int
+		Busy          int
+		QueueDepth    int
+		QueueCapacity int
+		ActiveRuns    int
+		Evaluated     uint64
+		// Rules counts the evaluations of every rule with an ID, keyed by ID, across every rule set of the engine
+		Rules map[string]RuleStats
+	}
+
+	// RuleStats counts how often a rule fired and how often it failed
+	RuleStats struct {
+		Evaluated uint64
+		Failed    uint64
+	}
+
+	ruleCounter struct {
+		evaluated uint64
+		failed    uint64
+	}
+)
+
+func newScheduler(size int, queueLength int) *scheduler {
+	s := &scheduler{
+		jobCh:  make(chan *Job, queueLength),
+		size:   size,
+		window: size,
+	}
+
+	s.workers.Add(size)
+	for i := 0; i < size; i++ {
+		go func() {
+			defer s.workers.Done()
+			for job := range s.jobCh {
+				atomic.AddInt64(&s.busy, 1)
+				evalResult := job.runtime.evaluate(job)
+				// the counters are updated before the run can see the result, so they are settled once it returns
+				atomic.AddInt64(&s.busy, -1)
+				atomic.AddUint64(&s.evaluated, 1)
+				job.runtime.resultCh <- evalResult
+			}
+		}()
+	}
+	return s
+}
+
+// close stops the workers once the queue is drained
+func (s *scheduler) close() {
+	close(s.jobCh)
+	s.workers.Wait()
+}
+
+func (s *scheduler) stats() Stats {
+	return Stats{
+		Workers:       s.size,
+		Busy:          int(atomic.LoadInt64(&s.busy)),
+		QueueDepth:    len(s.jobCh),
+		QueueCapacity: cap(s.jobCh),
+		ActiveRuns:    int(atomic.LoadInt64(&s.active)),
+		Evaluated:     atomic.LoadUint64(&s.evaluated),
+		Rules:         s.ruleStats(),
+	}
+}
+
+// count records an evaluation of the rule with the given ID
+func (s *scheduler) count(id string, failed bool) {
+	counter, ok := s.rules.Load(id)
+	if !ok {
+		counter, _ = s.rules.LoadOrStore(id, new(ruleCounter))
+	}
+	atomic.AddUint64(&counter.(*ruleCounter).evaluated, 1)
+	if failed {
+		atomic.AddUint64(&counter.(*ruleCounter).failed, 1)
+	}
+}
+
+func (s *scheduler) ruleStats() map[string]RuleStats {
+	rules := make(map[string]RuleStats)
+	s.rules.Range(func(id, counter interface{}) bool {
+		rules[id.(string)] = RuleStats{
+			Evaluated: atomic.LoadUint64(&counter.(*ruleCounter).evaluated),
+			Failed:    atomic.LoadUint64(&counter.(*ruleCounter).failed),
+		}
+		return true
+	})
+	return rules
+}
+
+// Stats returns the current state of the engine workers and their queue
+func (e *Engine) Stats() Stats {
+	return e.scheduler.stats()
+}
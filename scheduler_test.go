@@ -0,0 +1,89 @@
+package fished
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulerSharedWorkers(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	e := NewWithCustomWorkerSize(2)
+	e.Set(map[string]interface{}{"seed": 0}, generateWideRules(500), nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, errs := e.RunDefault()
+			assert.Nil(t, errs)
+			assert.Equal(t, float64(2*500-2), res)
+		}()
+	}
+	wg.Wait()
+
+	stats := e.Stats()
+	assert.Equal(t, 2, stats.Workers)
+	assert.Equal(t, 0, stats.Busy)
+	assert.Equal(t, 0, stats.QueueDepth)
+	assert.Equal(t, DefaultRuleLength, stats.QueueCapacity)
+	assert.Equal(t, 0, stats.ActiveRuns)
+	assert.Equal(t, uint64(50*1001), stats.Evaluated)
+
+	// concurrent runs never spawn more goroutines than the engine workers,
+	// the goroutines of the test itself may take a moment to exit
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > baseline+2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, runtime.NumGoroutine() <= baseline+2)
+	e.Close()
+}
+
+func TestSchedulerFairness(t *testing.T) {
+	rules := []Rule{
+		{Input: []string{"seed"}, Output: "small", Expression: "slow(seed)"},
+		{Input: []string{"small"}, Output: "small_end", Expression: "slow(small)"},
+	}
+	for i := 0; i < 400; i++ {
+		rules = append(rules, Rule{Input: []string{"seed"}, Output: fmt.Sprintf("large_%d", i), Expression: "slow(seed)"})
+	}
+	rules = append(rules, Rule{Input: []string{"large_0"}, Output: DefaultTarget, Expression: "large_0"})
+
+	e := NewWithCustomWorkerSize(2)
+	e.Set(map[string]interface{}{"seed": true}, rules, map[string]RuleFunction{
+		"slow": func(args ...interface{}) (interface{}, error) {
+			time.Sleep(time.Millisecond)
+			return args[0], nil
+		},
+	})
+	defer e.Close()
+
+	largeDone := make(chan struct{})
+	go func() {
+		e.RunDefault()
+		close(largeDone)
+	}()
+
+	// wait until the large run saturates the workers
+	for e.Stats().Busy < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	res, errs := e.RunContext(context.Background(), "small_end", GoalDirected())
+	assert.Nil(t, errs)
+	assert.Equal(t, true, res)
+
+	select {
+	case <-largeDone:
+		t.Fatal("small run waited for the whole large run")
+	default:
+	}
+	<-largeDone
+}
@@ -0,0 +1,58 @@
+package fished
+
+import (
+	"github.com/knetic/govaluate"
+)
+
+// snapshot is an immutable bundle of everything a run reads from the engine.
+// Setters publish a modified copy, so in-flight runs finish against the snapshot they started with.
+type snapshot struct {
+	facts       map[string]interface{}
+	rules       []Rule
+	functions   map[string]govaluate.ExpressionFunction
+	ruleSet     *RuleSet
+	compileErr  error
+	conflicts   conflictConfig
+	errorPolicy ErrorPolicy
+}
+
+// load returns the current snapshot without locking
+func (e *Engine) load() *snapshot {
+	return e.current.Load().(*snapshot)
+}
+
+// update publishes a copy of the current snapshot changed by modify. Writers are serialized,
+// readers never wait for them.
+func (e *Engine) update(modify func(s *snapshot)) {
+	e.writeLock.Lock()
+	defer e.writeLock.Unlock()
+
+	s := *e.load()
+	modify(&s)
+	e.current.Store(&s)
+}
+
+// compile rebuilds the rule set from the rules and rule functions of the snapshot.
+// Parse errors are kept and handled by Run under the error policy, since rule functions may be set after the rules.
+func (s *snapshot) compile() {
+	s.ruleSet, s.compileErr = compile(s.rules, s.functions)
+}
+
+func (s *snapshot) setFacts(facts map[string]interface{}) {
+	s.facts = make(map[string]interface{}, len(facts))
+	for key, value := range facts {
+		s.facts[key] = value
+	}
+}
+
+func (s *snapshot) setRules(rules []Rule) {
+	s.rules = make([]Rule, len(rules))
+	copy(s.rules, rules)
+}
+
+func (s *snapshot) setRuleFunctions(ruleFunctions map[string]RuleFunction) {
+	s.functions = make(map[string]govaluate.ExpressionFunction, len(ruleFunctions))
+	for key, value := range ruleFunctions {
+		s.functions[key] = expressionFunction(value)
+	}
+}
@@ -0,0 +1,58 @@
+package fished
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotSwap(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	// one worker stays blocked by the in-flight run
+	e := NewWithCustomWorkerSize(2)
+	defer e.Close()
+	e.Set(map[string]interface{}{"example": "old"}, []Rule{
+		{Input: []string{"example"}, Output: DefaultTarget, Expression: "wait(example)"},
+	}, map[string]RuleFunction{
+		"wait": func(args ...interface{}) (interface{}, error) {
+			close(started)
+			<-release
+			return args[0], nil
+		},
+	})
+
+	done := make(chan interface{})
+	go func() {
+		res, _ := e.RunDefault()
+		done <- res
+	}()
+	<-started
+
+	// setters publish right away instead of waiting for the in-flight run
+	updated := make(chan struct{})
+	go func() {
+		e.SetFacts(map[string]interface{}{"example": "new"})
+		e.SetRules([]Rule{
+			{Input: []string{"example"}, Output: DefaultTarget, Expression: "example + '!'"},
+		})
+		e.SetConflictStrategy(ConflictFirstWins)
+		close(updated)
+	}()
+
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatal("setters blocked behind the in-flight run")
+	}
+
+	res, errs := e.RunDefault()
+	assert.Nil(t, errs)
+	assert.Equal(t, "new!", res)
+
+	// the in-flight run finishes against the snapshot it started with
+	close(release)
+	assert.Equal(t, "old", <-done)
+}
@@ -0,0 +1,110 @@
+package fished
+
+import (
+	"context"
+	"sync"
+)
+
+type streamItem struct {
+	index int
+	facts map[string]interface{}
+	slot  chan Result
+}
+
+// Unordered lets a stream emit results as soon as they are ready instead of in input order
+func Unordered() RunOption {
+	return func(c *runConfig) {
+		c.unordered = true
+	}
+}
+
+// EvaluateStream evaluates every fact set received from in like Evaluate, with at most Concurrency fact sets in flight.
+// Results are emitted in input order unless Unordered is given, Result.Index is the position of the fact set in the stream.
+// The result channel is closed once in is closed and every result is emitted.
+// Once ctx is done no more fact sets are read, results not emitted yet are dropped and the result channel is closed
+// as soon as in-flight runs are aborted. Tracing is not supported in a stream and WithTrace is ignored.
+func (e *Engine) EvaluateStream(ctx context.Context, in <-chan map[string]interface{}, target string, opts ...RunOption) <-chan Result {
+	config := newRunConfig(opts)
+	config.trace = nil
+	concurrency := e.concurrency(config)
+
+	out := make(chan Result)
+	items := make(chan streamItem)
+	// slots holds the pending results of the fact sets in flight in input order, its capacity bounds the reordering
+	slots := make(chan chan Result, concurrency)
+
+	go func() {
+		defer close(slots)
+		defer close(items)
+		for index := 0; ; index++ {
+			item := streamItem{index: index}
+			select {
+			case facts, ok := <-in:
+				if !ok {
+					return
+				}
+				item.facts = facts
+			case <-ctx.Done():
+				return
+			}
+
+			if !config.unordered {
+				item.slot = make(chan Result, 1)
+				select {
+				case slots <- item.slot:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case items <- item:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var runs sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		runs.Add(1)
+		go func() {
+			defer runs.Done()
+			for item := range items {
+				result := Result{Index: item.index}
+				result.Value, result.Errors = e.evaluate(ctx, item.facts, target, config)
+				if item.slot != nil {
+					item.slot <- result
+					continue
+				}
+				select {
+				case out <- result:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+
+	runs.Add(1)
+	go func() {
+		defer runs.Done()
+		for slot := range slots {
+			select {
+			case result := <-slot:
+				select {
+				case out <- result:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		runs.Wait()
+		close(out)
+	}()
+	return out
+}
@@ -0,0 +1,73 @@
+package fished
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateStream(t *testing.T) {
+	e := batchEngine()
+	defer e.Close()
+
+	feed := func(n int) <-chan map[string]interface{} {
+		in := make(chan map[string]interface{})
+		go func() {
+			defer close(in)
+			for i := 0; i < n; i++ {
+				in <- map[string]interface{}{"user": float64(i)}
+			}
+		}()
+		return in
+	}
+
+	t.Run("ordered", func(t *testing.T) {
+		index := 0
+		for result := range e.EvaluateStream(context.Background(), feed(50), DefaultTarget, Concurrency(4)) {
+			assert.Equal(t, index, result.Index)
+			if index == 3 {
+				assert.Len(t, result.Errors, 1)
+			} else {
+				assert.Nil(t, result.Errors)
+				assert.Equal(t, float64(2*(index+1)), result.Value)
+			}
+			index++
+		}
+		assert.Equal(t, 50, index)
+	})
+
+	t.Run("unordered", func(t *testing.T) {
+		var indexes []int
+		for result := range e.EvaluateStream(context.Background(), feed(50), DefaultTarget, Unordered()) {
+			indexes = append(indexes, result.Index)
+		}
+		sort.Ints(indexes)
+		for i, index := range indexes {
+			assert.Equal(t, i, index)
+		}
+		assert.Len(t, indexes, 50)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		// the input is never closed, the stream has to stop on its own
+		in := make(chan map[string]interface{})
+		out := e.EvaluateStream(ctx, in, DefaultTarget)
+		in <- map[string]interface{}{"user": float64(0)}
+		result := <-out
+		assert.Equal(t, float64(2), result.Value)
+		cancel()
+
+		select {
+		case _, ok := <-out:
+			for ok {
+				_, ok = <-out
+			}
+		case <-time.After(time.Second):
+			t.Fatal("stream was not closed after cancel")
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package fished
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+func decodeTOMLRuleSetFile(data []byte) (*RuleSetFile, error) {
+	f := &RuleSetFile{}
+	md, err := toml.Decode(string(data), f)
+	if err != nil {
+		var parseErr toml.ParseError
+		if errors.As(err, &parseErr) {
+			start := parseErr.Position.Start
+			if start > len(data) {
+				start = len(data)
+			}
+			return nil, &LoadError{
+				Line:   parseErr.Position.Line,
+				Column: start - bytes.LastIndexByte(data[:start], '\n'),
+				Err:    err,
+			}
+		}
+		return nil, &LoadError{Line: lineOf(err), Err: err}
+	}
+
+	// TOML does not say which rule an unknown field belongs to, only its line is searched for
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		key := undecoded[0]
+		return nil, &LoadError{
+			Line: tomlKeyLine(data, key[len(key)-1]),
+			Err:  fmt.Errorf("unknown field %q", key.String()),
+		}
+	}
+	if err := f.checkFormatVersion(); err != nil {
+		return nil, &LoadError{Line: tomlKeyLine(data, "format_version"), Err: err}
+	}
+
+	var comments []string
+	f.Comment, comments = tomlComments(data)
+	for i := range f.Rules {
+		normalizeRule(&f.Rules[i])
+		if i < len(comments) {
+			f.Rules[i].Comment = comments[i]
+		}
+	}
+	return f, nil
+}
+
+// writeTOMLRuleSetFile writes the envelope of f, then every rule as a [[data]] table headed by its comment.
+// Multi-line expressions are written as multi-line literal strings.
+func writeTOMLRuleSetFile(w io.Writer, f *RuleSetFile) error {
+	var buf bytes.Buffer
+	if comment := commentLines(f.Comment); comment != "" {
+		buf.WriteString(comment + "\n\n")
+	}
+
+	envelope := *f
+	envelope.Rules = nil
+	if err := toml.NewEncoder(&buf).Encode(envelope); err != nil {
+		return err
+	}
+
+	for _, rule := range f.Rules {
+		buf.WriteString("\n")
+		if comment := commentLines(rule.Comment); comment != "" {
+			buf.WriteString(comment + "\n")
+		}
+
+		// whole numbers were read as float64, they are written back without a fraction
+		rule.Fallback = tomlNumbers(rule.Fallback)
+		if rule.Metadata != nil {
+			metadata := make(map[string]interface{}, len(rule.Metadata))
+			for key, value := range rule.Metadata {
+				metadata[key] = tomlNumbers(value)
+			}
+			rule.Metadata = metadata
+		}
+
+		var table bytes.Buffer
+		enc := toml.NewEncoder(&table)
+		enc.Indent = ""
+		if err := enc.Encode(struct {
+			Rules []Rule `toml:"data"`
+		}{[]Rule{rule}}); err != nil {
+			return err
+		}
+
+		encoded := table.String()
+		if strings.Contains(rule.Expression, "\n") && !strings.Contains(rule.Expression, "'''") {
+			quoted, err := tomlString(rule.Expression)
+			if err != nil {
+				return err
+			}
+			encoded = strings.Replace(encoded, "expression = "+quoted, "expression = '''\n"+rule.Expression+"\n'''", 1)
+		}
+		buf.WriteString(encoded)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// tomlNumbers turns whole float64 numbers of value into int64, copying the slices and maps it walks
+func tomlNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = tomlNumbers(v[i])
+		}
+		return values
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(v))
+		for key := range v {
+			values[key] = tomlNumbers(v[key])
+		}
+		return values
+	}
+	return value
+}
+
+// tomlString returns s as encoded by the TOML encoder
+func tomlString(s string) (string, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(map[string]string{"s": s}); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(buf.String(), "s = "), "\n"), nil
+}
+
+// tomlComments finds the comment heading the file, followed by a blank line or by the first field,
+// and the comment right above every [[data]] table. Lines of multi-line strings are skipped.
+func tomlComments(data []byte) (string, []string) {
+	var file string
+	var rules []string
+	var block []string
+	started := false
+	open := ""
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if open != "" {
+			open = tomlMultiline(line, open)
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "#"):
+			block = append(block, trimmed)
+			continue
+		case strings.ReplaceAll(trimmed, " ", "") == "[[data]]":
+			rules = append(rules, commentText(strings.Join(block, "\n")))
+		case !started && block != nil:
+			file = commentText(strings.Join(block, "\n"))
+		}
+		if trimmed != "" {
+			started = true
+		}
+		block = nil
+		open = tomlMultiline(line, "")
+	}
+	return file, rules
+}
+
+// tomlMultiline returns the delimiter of the multi-line string still open at the end of line,
+// given the one open at its start, or an empty string
+func tomlMultiline(line, open string) string {
+	for {
+		if open == "" {
+			start := strings.Index(line, `"""`)
+			if literal := strings.Index(line, "'''"); literal >= 0 && (start < 0 || literal < start) {
+				start = literal
+			}
+			if start < 0 {
+				return ""
+			}
+			open, line = line[start:start+3], line[start+3:]
+		}
+
+		end := strings.Index(line, open)
+		// a basic string may escape its quotes
+		for end > 0 && open == `"""` && line[end-1] == '\\' {
+			next := strings.Index(line[end+1:], open)
+			if next < 0 {
+				end = -1
+				break
+			}
+			end += next + 1
+		}
+		if end < 0 {
+			return open
+		}
+		open, line = "", line[end+3:]
+	}
+}
+
+// tomlKeyLine returns the first line setting key, 0 when there is none
+func tomlKeyLine(data []byte, key string) int {
+	pattern := regexp.MustCompile(`^\s*("` + regexp.QuoteMeta(key) + `"|` + regexp.QuoteMeta(key) + `)\s*=`)
+	open := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		if open == "" && pattern.MatchString(line) {
+			return i + 1
+		}
+		open = tomlMultiline(line, open)
+	}
+	return 0
+}
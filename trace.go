@@ -0,0 +1,141 @@
+package fished
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// Trace records every rule fired during a run, in the order their results were applied
+	Trace struct {
+		Initial map[string]interface{}
+		Steps   []TraceStep
+	}
+
+	// TraceStep describes a single fired rule.
+	// Kept is false when the value was nil, failed or lost to conflict resolution.
+	TraceStep struct {
+		Rule       int
+		RuleID     string
+		Expression string
+		Inputs     map[string]interface{}
+		Output     string
+		Value      interface{}
+		Kept       bool
+		Wave       int
+		Duration   time.Duration
+		Error      error
+	}
+
+	// Derivation is a node of the tree explaining how a fact was derived.
+	// Step is nil when the fact was one of the initial facts.
+	Derivation struct {
+		Fact   string
+		Value  interface{}
+		Step   *TraceStep
+		Inputs []*Derivation
+	}
+)
+
+// WithTrace records the execution trace of the run into t
+func WithTrace(t *Trace) RunOption {
+	return func(c *runConfig) {
+		c.trace = t
+	}
+}
+
+// reset prepares the trace for a new run starting from facts
+func (t *Trace) reset(facts map[string]interface{}) {
+	t.Initial = make(map[string]interface{}, len(facts))
+	for key, value := range facts {
+		t.Initial[key] = value
+	}
+	t.Steps = t.Steps[:0]
+}
+
+// record appends the step for a fired rule
+func (t *Trace) record(rs *RuleSet, evalResult *EvalResult, wave int, kept bool) {
+	t.Steps = append(t.Steps, TraceStep{
+		Rule:       evalResult.Rule,
+		RuleID:     rs.rules[evalResult.Rule].ID,
+		Expression: rs.rules[evalResult.Rule].Expression,
+		Inputs:     evalResult.Inputs,
+		Output:     evalResult.Key,
+		Value:      evalResult.Value,
+		Kept:       kept,
+		Wave:       wave,
+		Duration:   evalResult.Duration,
+		Error:      evalResult.Error,
+	})
+}
+
+// Explain returns the derivation tree of fact, or nil when the fact was never known during the run
+func (t *Trace) Explain(fact string) *Derivation {
+	return t.explain(fact, len(t.Steps))
+}
+
+// explain derives fact from the steps applied before the given step position
+func (t *Trace) explain(fact string, before int) *Derivation {
+	for k := before - 1; k >= 0; k-- {
+		step := &t.Steps[k]
+		if step.Output != fact || !step.Kept {
+			continue
+		}
+
+		d := &Derivation{
+			Fact:  fact,
+			Value: step.Value,
+			Step:  step,
+		}
+
+		// inputs were read before the wave of this step started
+		first := k
+		for first > 0 && t.Steps[first-1].Wave == step.Wave {
+			first--
+		}
+		for _, input := range sortedKeys(step.Inputs) {
+			if input := t.explain(input, first); input != nil {
+				d.Inputs = append(d.Inputs, input)
+			}
+		}
+		return d
+	}
+
+	if value, ok := t.Initial[fact]; ok {
+		return &Derivation{
+			Fact:  fact,
+			Value: value,
+		}
+	}
+	return nil
+}
+
+// String renders the derivation as an indented tree
+func (d *Derivation) String() string {
+	var sb strings.Builder
+	d.render(&sb, 0)
+	return sb.String()
+}
+
+func (d *Derivation) render(sb *strings.Builder, depth int) {
+	sb.WriteString(strings.Repeat("  ", depth))
+	if d.Step == nil {
+		fmt.Fprintf(sb, "%s = %v (initial fact)\n", d.Fact, d.Value)
+	} else {
+		fmt.Fprintf(sb, "%s = %v (%s, wave %d: %s)\n", d.Fact, d.Value, ruleName(d.Step.Rule, d.Step.RuleID), d.Step.Wave, d.Step.Expression)
+	}
+	for _, input := range d.Inputs {
+		input.render(sb, depth+1)
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
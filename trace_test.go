@@ -0,0 +1,92 @@
+package fished
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrace(t *testing.T) {
+	rules := []Rule{
+		{Input: []string{"account_partner"}, Output: "account_type", Expression: "account_partner == 'hello' ? 'free' : 'paid'"},
+		{Input: []string{"account_partner"}, Output: "flight_type", Expression: "account_partner == 'hello' ? 'free' : 'paid'"},
+		{Input: []string{"flight_type"}, Output: "flight_type_eligible", Expression: "flight_type == 'free'"},
+		{Input: []string{"account_type"}, Output: "account_type_eligible", Expression: "account_type == 'free'"},
+		{Input: []string{"account_region"}, Output: "account_region_eligible", Expression: "geo(account_region)"},
+		{Input: []string{"account_type_eligible", "flight_type_eligible", "account_region_eligible"}, Output: "result_end", Expression: "account_type_eligible && flight_type_eligible && account_region_eligible"},
+	}
+	facts := map[string]interface{}{
+		"account_partner": "hello",
+		"account_region":  "ID",
+		"flight_type":     "free",
+	}
+
+	t.Run("derivation of the target", func(t *testing.T) {
+		e := New()
+		defer e.Close()
+		e.Set(facts, rules, map[string]RuleFunction{
+			"geo": func(args ...interface{}) (interface{}, error) {
+				return args[0] == "ID", nil
+			},
+		})
+
+		var trace Trace
+		res, errs := e.RunContext(context.Background(), DefaultTarget, WithTrace(&trace))
+		assert.Nil(t, errs)
+		assert.Equal(t, true, res)
+		assert.Equal(t, facts, trace.Initial)
+
+		if !assert.Len(t, trace.Steps, 6) {
+			return
+		}
+		assert.Equal(t, []int{0, 1, 4, 2, 3, 5}, []int{
+			trace.Steps[0].Rule, trace.Steps[1].Rule, trace.Steps[2].Rule,
+			trace.Steps[3].Rule, trace.Steps[4].Rule, trace.Steps[5].Rule,
+		})
+		last := trace.Steps[5]
+		assert.Equal(t, 3, last.Wave)
+		assert.Equal(t, DefaultTarget, last.Output)
+		assert.Equal(t, true, last.Value)
+		assert.Equal(t, map[string]interface{}{
+			"account_type_eligible":   true,
+			"flight_type_eligible":    true,
+			"account_region_eligible": true,
+		}, last.Inputs)
+
+		assert.Equal(t, ""+
+			"result_end = true (rule 5, wave 3: account_type_eligible && flight_type_eligible && account_region_eligible)\n"+
+			"  account_region_eligible = true (rule 4, wave 1: geo(account_region))\n"+
+			"    account_region = ID (initial fact)\n"+
+			"  account_type_eligible = true (rule 3, wave 2: account_type == 'free')\n"+
+			"    account_type = free (rule 0, wave 1: account_partner == 'hello' ? 'free' : 'paid')\n"+
+			"      account_partner = hello (initial fact)\n"+
+			"  flight_type_eligible = true (rule 2, wave 2: flight_type == 'free')\n"+
+			"    flight_type = free (rule 1, wave 1: account_partner == 'hello' ? 'free' : 'paid')\n"+
+			"      account_partner = hello (initial fact)\n",
+			trace.Explain(DefaultTarget).String())
+		assert.Nil(t, trace.Explain("unknown"))
+	})
+
+	t.Run("failing rule", func(t *testing.T) {
+		geoErr := errors.New("geo lookup failed")
+		e := New()
+		defer e.Close()
+		e.Set(facts, rules, map[string]RuleFunction{
+			"geo": func(args ...interface{}) (interface{}, error) {
+				return nil, geoErr
+			},
+		})
+
+		var trace Trace
+		res, errs := e.RunContext(context.Background(), DefaultTarget, WithTrace(&trace))
+		assert.Nil(t, res)
+		assert.Len(t, errs, 1)
+		if assert.Len(t, trace.Steps, 5) {
+			assert.Equal(t, 4, trace.Steps[2].Rule)
+			assert.Error(t, trace.Steps[2].Error)
+		}
+		assert.Nil(t, trace.Explain(DefaultTarget))
+	})
+}
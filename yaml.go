@@ -0,0 +1,216 @@
+package fished
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// errorLine finds the line number in errors of the YAML and TOML parsers
+var errorLine = regexp.MustCompile(`line (\d+)`)
+
+func decodeYAMLRuleSetFile(data []byte) (*RuleSetFile, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, &LoadError{Line: lineOf(err), Err: err}
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, &LoadError{Line: 1, Column: 1, Err: errors.New("rule set must be a YAML mapping")}
+	}
+
+	root := doc.Content[0]
+	// a comment right above the first field, without a blank line in between, heads the file too
+	comment := doc.HeadComment
+	if comment == "" && len(root.Content) > 0 {
+		comment = root.Content[0].HeadComment
+	}
+	f := &RuleSetFile{
+		Comment: commentText(comment),
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, node := root.Content[i], root.Content[i+1]
+		var value interface{}
+		switch key.Value {
+		case "format_version":
+			value = &f.FormatVersion
+		case "name":
+			value = &f.Name
+		case "version":
+			value = &f.Version
+		case "target":
+			value = &f.Target
+		case "data":
+			rules, err := decodeYAMLRules(node)
+			if err != nil {
+				return nil, err
+			}
+			f.Rules = rules
+			continue
+		default:
+			return nil, yamlLoadError(key, fmt.Errorf("unknown field %q", key.Value))
+		}
+
+		if err := node.Decode(value); err != nil {
+			return nil, yamlLoadError(node, err)
+		}
+		if key.Value == "format_version" {
+			if err := f.checkFormatVersion(); err != nil {
+				return nil, yamlLoadError(node, err)
+			}
+		}
+	}
+
+	if f.FormatVersion == 0 {
+		f.FormatVersion = 1
+	}
+	return f, nil
+}
+
+// decodeYAMLRules decodes the rules of the data sequence, the comment heading a rule becomes its Comment
+func decodeYAMLRules(node *yaml.Node) ([]Rule, error) {
+	if node.Tag == "!!null" {
+		return nil, nil
+	}
+	if node.Kind != yaml.SequenceNode {
+		return nil, yamlLoadError(node, errors.New("data must be a sequence of rules"))
+	}
+
+	rules := make([]Rule, len(node.Content))
+	for i, item := range node.Content {
+		if item.Kind != yaml.MappingNode {
+			return nil, yamlLoadError(item, fmt.Errorf("rule %d must be a mapping", i))
+		}
+		for k := 0; k < len(item.Content); k += 2 {
+			if _, ok := ruleFields[FormatYAML][item.Content[k].Value]; !ok {
+				return nil, yamlLoadError(item.Content[k], fmt.Errorf("rule %d: unknown field %q", i, item.Content[k].Value))
+			}
+		}
+		if err := item.Decode(&rules[i]); err != nil {
+			return nil, yamlLoadError(item, fmt.Errorf("rule %d: %w", i, err))
+		}
+		normalizeRule(&rules[i])
+		rules[i].Comment = commentText(item.HeadComment)
+	}
+	return rules, nil
+}
+
+// writeYAMLRuleSetFile writes f with its comments, inputs and tags on one line and multi-line expressions as literal blocks
+func writeYAMLRuleSetFile(w io.Writer, f *RuleSetFile) error {
+	var root yaml.Node
+	if err := root.Encode(f); err != nil {
+		return err
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "data" {
+			continue
+		}
+		for k, item := range root.Content[i+1].Content {
+			item.HeadComment = commentLines(f.Rules[k].Comment)
+			for j := 0; j+1 < len(item.Content); j += 2 {
+				switch value := item.Content[j+1]; item.Content[j].Value {
+				case "input", "tags":
+					value.Style = yaml.FlowStyle
+				case "expression":
+					switch {
+					case strings.Contains(value.Value, "\n"):
+						value.Style = yaml.LiteralStyle
+					case strings.Contains(value.Value, "'"):
+						// govaluate strings are single quoted, double quotes keep them readable
+						value.Style = yaml.DoubleQuotedStyle
+					}
+				}
+			}
+		}
+	}
+
+	doc := &yaml.Node{
+		Kind:        yaml.DocumentNode,
+		HeadComment: commentLines(f.Comment),
+		Content:     []*yaml.Node{&root},
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func yamlLoadError(node *yaml.Node, err error) *LoadError {
+	return &LoadError{
+		Line:   node.Line,
+		Column: node.Column,
+		Err:    err,
+	}
+}
+
+// lineOf extracts the line number from a parser error, 0 when it has none
+func lineOf(err error) int {
+	match := errorLine.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0
+	}
+	line, _ := strconv.Atoi(match[1])
+	return line
+}
+
+// commentText strips the comment markers of a YAML or TOML comment
+func commentText(comment string) string {
+	if comment == "" {
+		return ""
+	}
+	lines := strings.Split(comment, "\n")
+	for i, line := range lines {
+		line = strings.TrimPrefix(strings.TrimSpace(line), "#")
+		lines[i] = strings.TrimPrefix(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// commentLines turns text back into YAML or TOML comment lines
+func commentLines(text string) string {
+	if text == "" {
+		return ""
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("# "+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// normalizeRule makes rules read from YAML and TOML equal to the same rules read from JSON:
+// numbers are float64, and the trailing line break of multi-line expressions is dropped
+func normalizeRule(rule *Rule) {
+	rule.Expression = strings.TrimRight(rule.Expression, "\n")
+	rule.Fallback = normalizeNumbers(rule.Fallback)
+	for key, value := range rule.Metadata {
+		rule.Metadata[key] = normalizeNumbers(value)
+	}
+}
+
+func normalizeNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeNumbers(v[i])
+		}
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = normalizeNumbers(v[key])
+		}
+	}
+	return value
+}